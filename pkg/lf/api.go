@@ -1,8 +1,11 @@
 package lf
 
 import (
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"strings"
 
@@ -43,12 +46,13 @@ type APIStatus struct {
 // this from other places. The Proxy accepts requests to localhosts, passed through queries,
 // but intercepts puts and builds records locally and then submits them in Data to a full node.
 type APIPut struct {
-	Data            []byte    `msgpack:"D,omitempty" json:",omitempty"`   // Fully encoded record data, overrides other fields if present
-	Key             []byte    `msgpack:"K,omitempty" json:",omitempty"`   // Plain text key
-	Value           []byte    `msgpack:"V,omitempty" json:",omitempty"`   // Plain text value
-	OwnerPrivateKey []byte    `msgpack:"OPK,omitempty" json:",omitempty"` // Owner private key to sign record
-	Selectors       [2][]byte `msgpack:"S,omitempty" json:",omitempty"`   // Selectors
-	PlainTextValue  bool      `msgpack:"PTV"`                             // If true, do not encrypt value in record
+	Data            []byte            `msgpack:"D,omitempty" json:",omitempty"`   // Fully encoded record data, overrides other fields if present
+	Key             []byte            `msgpack:"K,omitempty" json:",omitempty"`   // Plain text key
+	Value           []byte            `msgpack:"V,omitempty" json:",omitempty"`   // Plain text value
+	OwnerPrivateKey []byte            `msgpack:"OPK,omitempty" json:",omitempty"` // Owner private key to sign record
+	Selectors       [2][]byte         `msgpack:"S,omitempty" json:",omitempty"`   // Selectors
+	PlainTextValue  bool              `msgpack:"PTV"`                             // If true, do not encrypt value in record
+	OIDCAuth        *OIDCAuthEnvelope `msgpack:"OA,omitempty" json:",omitempty"`  // Optional OIDC ID token authorizing this put in place of a cap:put grant
 }
 
 // APIGet (/g) gets records by search keys.
@@ -180,6 +184,62 @@ func apiCreateHTTPServeMux(n *Node) *http.ServeMux {
 	// css, and txt. Other extensions will return 404. No extension returns value with type application/octet-stream.
 	smux.HandleFunc("/k/", func(out http.ResponseWriter, req *http.Request) {
 		if req.Method == http.MethodGet || req.Method == http.MethodHead {
+			name := strings.TrimPrefix(req.URL.Path, "/k/")
+			ext := ""
+			if dot := strings.LastIndexByte(name, '.'); dot >= 0 {
+				ext = strings.ToLower(name[dot+1:])
+				name = name[:dot]
+			}
+
+			var key []byte
+			if strings.HasPrefix(name, "_") {
+				decoded, err := base64.RawURLEncoding.DecodeString(name[1:])
+				if err != nil {
+					apiSendJSON(out, req, http.StatusNotFound, &APIError{Code: http.StatusNotFound, Message: "invalid base64url key"})
+					return
+				}
+				key = decoded
+			} else {
+				key = []byte(name)
+			}
+
+			rec, value, err := n.db.getBestByKey(key)
+			if err != nil || rec == nil {
+				apiSendJSON(out, req, http.StatusNotFound, &APIError{Code: http.StatusNotFound, Message: "not found"})
+				return
+			}
+
+			contentType := "application/octet-stream"
+			switch ext {
+			case "":
+			case "json", "msgpack":
+				var r apiRenderer = jsonRenderer{}
+				if ext == "msgpack" {
+					r = msgpackRenderer{contentType: "application/x-msgpack"}
+				}
+				out.Header().Set("Pragma", "no-cache")
+				out.Header().Set("Cache-Control", "no-cache")
+				if req.Method == http.MethodHead {
+					out.Header().Set("Content-Type", r.ContentType())
+					out.WriteHeader(http.StatusOK)
+					return
+				}
+				_ = r.Render(out, &APIRecordDetail{Record: *rec, Key: key, Value: value}, http.StatusOK)
+				return
+			default:
+				ct, ok := kExtContentTypes[ext]
+				if !ok {
+					apiSendJSON(out, req, http.StatusNotFound, &APIError{Code: http.StatusNotFound, Message: ext + " is not a supported extension"})
+					return
+				}
+				contentType = ct
+			}
+
+			out.Header().Set("Content-Type", contentType)
+			out.WriteHeader(http.StatusOK)
+			if req.Method != http.MethodHead {
+				_, _ = out.Write(value)
+			}
 		} else {
 			apiSendJSON(out, req, http.StatusMethodNotAllowed, &APIError{Code: http.StatusMethodNotAllowed, Message: req.Method + " not supported for this path"})
 		}
@@ -188,29 +248,98 @@ func apiCreateHTTPServeMux(n *Node) *http.ServeMux {
 	// Post a record, takes APIPut payload or just a raw record.
 	smux.HandleFunc("/p", func(out http.ResponseWriter, req *http.Request) {
 		if req.Method == http.MethodPost || req.Method == http.MethodPut {
+			observeRequestBodyFormat(req)
+
 			// Handle submission of raw records in raw record format with no enclosing object.
 			ct, haveCT := req.Header["Content-Type"]
 			if haveCT {
 				for i := range ct {
 					if strings.Contains(ct[i], "application/x-lf-record") {
 						var rdata [RecordMaxSize]byte
-						n, _ := io.ReadFull(req.Body, rdata[:])
-						if n > RecordMinSize {
-						} else {
-							apiSendJSON(out, req, http.StatusBadRequest, &APIError{Code: http.StatusBadRequest, Message: "invalid or malformed payload"})
+						rlen, _ := io.ReadFull(req.Body, rdata[:])
+						if rlen > RecordMinSize {
+							rec, err := NewRecordFromBytes(rdata[:rlen])
+							if err != nil {
+								metricRequestsTotal("/p", "error")
+								apiSendJSON(out, req, http.StatusBadRequest, &APIError{Code: http.StatusBadRequest, Message: "invalid or malformed payload"})
+								return
+							}
+							if !apiAuthorize(n, req, CapPut) {
+								metricAuthFailuresTotal("/p")
+								apiSendJSON(out, req, http.StatusForbidden, &APIError{Code: http.StatusForbidden, Message: "submitting records requires the cap:put capability"})
+								return
+							}
+							if err := n.addRecord(rec); err != nil {
+								metricRequestsTotal("/p", "error")
+								apiSendJSON(out, req, http.StatusBadRequest, &APIError{Code: http.StatusBadRequest, Message: err.Error()})
+								return
+							}
+							metricRecordsImportedTotal.Add(1)
+							metricRequestsTotal("/p", "ok")
+							apiSendJSON(out, req, http.StatusOK, &APIError{Code: http.StatusOK, Message: "accepted"})
 							return
 						}
+						metricRequestsTotal("/p", "error")
+						apiSendJSON(out, req, http.StatusBadRequest, &APIError{Code: http.StatusBadRequest, Message: "invalid or malformed payload"})
+						return
 					}
 				}
 			}
 
 			var put APIPut
-			if apiReadJSON(out, req, &put) == nil {
-				if len(put.Data) > 0 {
-				} else if apiIsTrusted(n, req) {
-				} else {
-					apiSendJSON(out, req, http.StatusForbidden, &APIError{Code: http.StatusForbidden, Message: "node will only build records locally if submitted from authorized hosts"})
+			if apiReadJSON(out, req, &put) != nil {
+				return
+			}
+
+			if len(put.Data) > 0 {
+				rec, err := NewRecordFromBytes(put.Data)
+				if err != nil {
+					metricRequestsTotal("/p", "error")
+					apiSendJSON(out, req, http.StatusBadRequest, &APIError{Code: http.StatusBadRequest, Message: "invalid or malformed payload"})
+					return
+				}
+				if !apiAuthorize(n, req, CapPut) && !apiAuthorizeRecordOIDC(n, rec, put.OIDCAuth) {
+					metricAuthFailuresTotal("/p")
+					apiSendJSON(out, req, http.StatusForbidden, &APIError{Code: http.StatusForbidden, Message: "submitting records requires either the cap:put capability or a verifiable OIDC authorization"})
+					return
+				}
+				if err := n.addRecord(rec); err != nil {
+					metricRequestsTotal("/p", "error")
+					apiSendJSON(out, req, http.StatusBadRequest, &APIError{Code: http.StatusBadRequest, Message: err.Error()})
+					return
+				}
+				metricRecordsImportedTotal.Add(1)
+				metricRequestsTotal("/p", "ok")
+				apiSendJSON(out, req, http.StatusOK, &APIError{Code: http.StatusOK, Message: "accepted"})
+			} else if apiAuthorize(n, req, requiredPutCapability(&put), CapRecordBuild) {
+				owner, err := NewOwnerFromPrivateBytes(put.OwnerPrivateKey)
+				if err != nil {
+					metricRequestsTotal("/p", "error")
+					apiSendJSON(out, req, http.StatusBadRequest, &APIError{Code: http.StatusBadRequest, Message: "invalid owner private key"})
+					return
 				}
+				var plainTextKey []byte
+				if put.PlainTextValue {
+					plainTextKey = put.Selectors[0]
+				}
+				wg := NewWharrgarblr(RecordDefaultWharrgarblMemory, 0)
+				rec, err := NewRecord(RecordTypeDatum, put.Value, nil, nil, put.Selectors[:], plainTextKey, nil, TimeSec(), wg, owner)
+				if err != nil {
+					metricRequestsTotal("/p", "error")
+					apiSendJSON(out, req, http.StatusBadRequest, &APIError{Code: http.StatusBadRequest, Message: err.Error()})
+					return
+				}
+				if err := n.addRecord(rec); err != nil {
+					metricRequestsTotal("/p", "error")
+					apiSendJSON(out, req, http.StatusBadRequest, &APIError{Code: http.StatusBadRequest, Message: err.Error()})
+					return
+				}
+				metricRecordsImportedTotal.Add(1)
+				metricRequestsTotal("/p", "ok")
+				apiSendJSON(out, req, http.StatusOK, &APIRecordDetail{Record: *rec, Key: put.Key})
+			} else {
+				metricAuthFailuresTotal("/p")
+				apiSendJSON(out, req, http.StatusForbidden, &APIError{Code: http.StatusForbidden, Message: "node will only build records locally for clients holding cap:record-build and the relevant cap:put grant"})
 			}
 		} else {
 			apiSendJSON(out, req, http.StatusMethodNotAllowed, &APIError{Code: http.StatusMethodNotAllowed, Message: req.Method + " not supported for this path"})
@@ -220,6 +349,35 @@ func apiCreateHTTPServeMux(n *Node) *http.ServeMux {
 	// Get record, takes APIGet payload for parameters. (Ironically /g must be gotten with PUT or POST!)
 	smux.HandleFunc("/g", func(out http.ResponseWriter, req *http.Request) {
 		if req.Method == http.MethodPost || req.Method == http.MethodPut {
+			observeRequestBodyFormat(req)
+
+			var get APIGet
+			if apiReadJSON(out, req, &get) != nil {
+				metricRequestsTotal("/g", "error")
+				return
+			}
+			if get.MaxResults == 0 || get.MaxResults > APIMaxResults {
+				get.MaxResults = APIMaxResults
+			}
+
+			results, err := n.db.getByQuery(&get)
+			if err != nil {
+				metricRequestsTotal("/g", "error")
+				apiSendJSON(out, req, http.StatusInternalServerError, &APIError{Code: http.StatusInternalServerError, Message: err.Error()})
+				return
+			}
+
+			if len(get.Key) > 0 {
+				for i := range results {
+					if plainTextValue, err := results[i].Record.Decrypt(get.Key); err == nil {
+						results[i].Key = get.Key
+						results[i].Value = plainTextValue
+					}
+				}
+			}
+
+			apiNegotiateRenderer(req).Render(out, results, http.StatusOK)
+			metricRequestsTotal("/g", "ok")
 		} else {
 			apiSendJSON(out, req, http.StatusMethodNotAllowed, &APIError{Code: http.StatusMethodNotAllowed, Message: req.Method + " not supported for this path"})
 		}
@@ -231,6 +389,81 @@ func apiCreateHTTPServeMux(n *Node) *http.ServeMux {
 	// each record prefixed by a 16-bit (big-endian) record size.
 	smux.HandleFunc("/r", func(out http.ResponseWriter, req *http.Request) {
 		if req.Method == http.MethodPost || req.Method == http.MethodPut {
+			body, err := ioutil.ReadAll(io.LimitReader(req.Body, int64(RecordMaxSize)*int64(APIMaxResults)))
+			if err != nil || len(body) == 0 || len(body)%32 != 0 {
+				metricRequestsTotal("/r", "error")
+				apiSendJSON(out, req, http.StatusBadRequest, &APIError{Code: http.StatusBadRequest, Message: "payload must be a non-empty multiple of 32 bytes"})
+				return
+			}
+
+			out.Header().Set("Content-Type", "application/x-lf-record-stream")
+			out.WriteHeader(http.StatusOK)
+			if req.Method != http.MethodHead {
+				var lenBuf [2]byte
+				for i := 0; i+32 <= len(body); i += 32 {
+					var h [32]byte
+					copy(h[:], body[i:i+32])
+					rec, err := n.db.getByHash(h)
+					if err != nil || rec == nil {
+						continue
+					}
+					data := rec.Bytes()
+					binary.BigEndian.PutUint16(lenBuf[:], uint16(len(data)))
+					out.Write(lenBuf[:])
+					out.Write(data)
+				}
+			}
+			metricRequestsTotal("/r", "ok")
+		} else {
+			apiSendJSON(out, req, http.StatusMethodNotAllowed, &APIError{Code: http.StatusMethodNotAllowed, Message: req.Method + " not supported for this path"})
+		}
+	})
+
+	// Execute a Query (selector ranges rather than exact selector IDs), optionally following
+	// LF-REDIRECT/LF-CNAME chains server-side so a recursive lookup costs one round trip
+	// instead of one per hop. RemoteNode.ExecuteQuery still does this client-side as a
+	// fallback for nodes that predate this handler, which is why a non-recursive or
+	// already-resolved response (Chain already populated) is left untouched by it.
+	smux.HandleFunc("/query", func(out http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodPost || req.Method == http.MethodPut {
+			observeRequestBodyFormat(req)
+
+			var q Query
+			if apiReadJSON(out, req, &q) != nil {
+				metricRequestsTotal("/query", "error")
+				return
+			}
+			if q.MaxResults == 0 || q.MaxResults > APIMaxResults {
+				q.MaxResults = APIMaxResults
+			}
+
+			results, err := n.db.getByQueryRange(&q)
+			if err != nil {
+				metricRequestsTotal("/query", "error")
+				apiSendJSON(out, req, http.StatusInternalServerError, &APIError{Code: http.StatusInternalServerError, Message: err.Error()})
+				return
+			}
+
+			if !q.Recursive || len(results) == 0 {
+				apiNegotiateRenderer(req).Render(out, queryResultsFromDetails(results), http.StatusOK)
+				metricRequestsTotal("/query", "ok")
+				return
+			}
+
+			resolved, err := resolveRecursive(results, q.Owner, q.MaxDepth, func(sub *Query) ([]APIRecordDetail, error) {
+				if sub.MaxResults == 0 || sub.MaxResults > APIMaxResults {
+					sub.MaxResults = APIMaxResults
+				}
+				return n.db.getByQueryRange(sub)
+			})
+			if err != nil {
+				metricRequestsTotal("/query", "error")
+				apiSendJSON(out, req, http.StatusInternalServerError, &APIError{Code: http.StatusInternalServerError, Message: err.Error()})
+				return
+			}
+
+			apiNegotiateRenderer(req).Render(out, resolved, http.StatusOK)
+			metricRequestsTotal("/query", "ok")
 		} else {
 			apiSendJSON(out, req, http.StatusMethodNotAllowed, &APIError{Code: http.StatusMethodNotAllowed, Message: req.Method + " not supported for this path"})
 		}
@@ -238,6 +471,11 @@ func apiCreateHTTPServeMux(n *Node) *http.ServeMux {
 
 	smux.HandleFunc("/peers", func(out http.ResponseWriter, req *http.Request) {
 		if req.Method == http.MethodGet || req.Method == http.MethodHead {
+			if !apiAuthorize(n, req, CapStatusPeers) {
+				metricAuthFailuresTotal("/peers")
+				apiSendJSON(out, req, http.StatusForbidden, &APIError{Code: http.StatusForbidden, Message: "viewing peers requires the cap:status-peers capability"})
+				return
+			}
 			apiSendJSON(out, req, http.StatusOK, apiMakePeerArray(n))
 		} else {
 			apiSendJSON(out, req, http.StatusMethodNotAllowed, &APIError{Code: http.StatusMethodNotAllowed, Message: req.Method + " not supported for this path"})
@@ -246,15 +484,84 @@ func apiCreateHTTPServeMux(n *Node) *http.ServeMux {
 
 	smux.HandleFunc("/connect", func(out http.ResponseWriter, req *http.Request) {
 		if req.Method == http.MethodPost || req.Method == http.MethodPut {
-			if apiIsTrusted(n, req) {
+			if apiAuthorize(n, req, CapConnect) {
+				var peer APIPeer
+				if apiReadJSON(out, req, &peer) != nil {
+					metricRequestsTotal("/connect", "error")
+					return
+				}
+				if err := n.connectHost(peer.IP, int(peer.Port)); err != nil {
+					metricRequestsTotal("/connect", "error")
+					apiSendJSON(out, req, http.StatusBadRequest, &APIError{Code: http.StatusBadRequest, Message: err.Error()})
+					return
+				}
+				metricRequestsTotal("/connect", "ok")
+				apiSendJSON(out, req, http.StatusOK, &APIError{Code: http.StatusOK, Message: "connecting"})
 			} else {
-				apiSendJSON(out, req, http.StatusForbidden, &APIError{Code: http.StatusForbidden, Message: "peers may only be submitted by trusted hosts"})
+				metricAuthFailuresTotal("/connect")
+				apiSendJSON(out, req, http.StatusForbidden, &APIError{Code: http.StatusForbidden, Message: "submitting peers requires the cap:connect capability"})
 			}
 		} else {
 			apiSendJSON(out, req, http.StatusMethodNotAllowed, &APIError{Code: http.StatusMethodNotAllowed, Message: req.Method + " not supported for this path"})
 		}
 	})
 
+	// Mint a new capability token. Trusted-host (localhost) only: this is how an operator
+	// bootstraps delegated access for remote clients without handing out node-local trust.
+	smux.HandleFunc("/auth/mint", func(out http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost && req.Method != http.MethodPut {
+			apiSendJSON(out, req, http.StatusMethodNotAllowed, &APIError{Code: http.StatusMethodNotAllowed, Message: req.Method + " not supported for this path"})
+			return
+		}
+		if !apiIsTrusted(n, req) {
+			apiSendJSON(out, req, http.StatusForbidden, &APIError{Code: http.StatusForbidden, Message: "minting tokens is only permitted from trusted hosts"})
+			return
+		}
+		if n.capAuth == nil {
+			apiSendJSON(out, req, http.StatusServiceUnavailable, &APIError{Code: http.StatusServiceUnavailable, Message: "this node has no capability authority configured"})
+			return
+		}
+		var mintReq struct {
+			Grants  []string `json:"grants"`
+			Expires uint64   `json:"expires,omitempty"`
+		}
+		if apiReadJSON(out, req, &mintReq) != nil {
+			return
+		}
+		token, err := n.capAuth.Mint(mintReq.Grants, mintReq.Expires)
+		if err != nil {
+			apiSendJSON(out, req, http.StatusInternalServerError, &APIError{Code: http.StatusInternalServerError, Message: err.Error()})
+			return
+		}
+		apiSendJSON(out, req, http.StatusOK, &struct {
+			Token string `json:"token"`
+		}{Token: token})
+	})
+
+	// Revoke (blacklist) a previously minted capability token. Trusted-host only.
+	smux.HandleFunc("/auth/revoke", func(out http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost && req.Method != http.MethodPut {
+			apiSendJSON(out, req, http.StatusMethodNotAllowed, &APIError{Code: http.StatusMethodNotAllowed, Message: req.Method + " not supported for this path"})
+			return
+		}
+		if !apiIsTrusted(n, req) {
+			apiSendJSON(out, req, http.StatusForbidden, &APIError{Code: http.StatusForbidden, Message: "revoking tokens is only permitted from trusted hosts"})
+			return
+		}
+		if n.capAuth == nil {
+			apiSendJSON(out, req, http.StatusServiceUnavailable, &APIError{Code: http.StatusServiceUnavailable, Message: "this node has no capability authority configured"})
+			return
+		}
+		var revokeReq struct {
+			Token string `json:"token"`
+		}
+		if apiReadJSON(out, req, &revokeReq) != nil {
+			return
+		}
+		n.capAuth.Revoke(revokeReq.Token)
+		apiSendJSON(out, req, http.StatusOK, &APIError{Code: http.StatusOK, Message: "revoked"})
+	})
+
 	smux.HandleFunc("/status", func(out http.ResponseWriter, req *http.Request) {
 		rc, ds := n.db.stats()
 		var s APIStatus
@@ -272,6 +579,12 @@ func apiCreateHTTPServeMux(n *Node) *http.ServeMux {
 		apiSendJSON(out, req, http.StatusOK, &s)
 	})
 
+	apiAddRPCHandlers(smux, n)
+	apiAddSubscribeHandler(smux, n)
+	apiAddStreamHandlers(smux, n)
+	apiAddMetricsHandlers(smux, n)
+	apiAddBugreportHandler(smux, n)
+
 	smux.HandleFunc("/", func(out http.ResponseWriter, req *http.Request) {
 		if req.Method == http.MethodGet || req.Method == http.MethodHead {
 			if req.URL.Path == "/" {
@@ -284,4 +597,4 @@ func apiCreateHTTPServeMux(n *Node) *http.ServeMux {
 	})
 
 	return smux
-}
\ No newline at end of file
+}
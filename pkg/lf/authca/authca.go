@@ -0,0 +1,199 @@
+/*
+ * LF: Global Fully Replicated Key/Value Store
+ * Copyright (C) 2018-2019  ZeroTier, Inc.  https://www.zerotier.com/
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ *
+ * --
+ *
+ * You can be released from the requirements of the license by purchasing
+ * a commercial license. Buying such a license is mandatory as soon as you
+ * develop commercial closed-source software that incorporates or links
+ * directly against ZeroTier software without disclosing the source code
+ * of your own application.
+ */
+
+// Package authca implements an ACME (RFC 8555) client that keeps a node's record-signing
+// certificate provisioned and renewed against a public or private CA, in the style of
+// xenolf/lego. It is used to fill GenesisParameters.AuthCertificates from a real PKI instead
+// of requiring the genesis owner to hand-assemble a DER blob.
+package authca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"time"
+
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/lego"
+	"github.com/go-acme/lego/v4/registration"
+)
+
+// ErrNoCertificate is returned by CertStore.Current when no certificate has been issued yet.
+var ErrNoCertificate = errors.New("no certificate has been issued yet")
+
+// Provider is implemented by DNS-01 challenge providers. It mirrors lego's challenge.Provider
+// so that any of lego's ~100 built-in DNS provider implementations can be wrapped directly.
+type Provider = challenge.Provider
+
+// Account is the ACME account identity used to register with and authenticate to the CA.
+// It satisfies lego's registration.User interface.
+type Account struct {
+	Email        string
+	Registration *registration.Resource
+	key          crypto.PrivateKey
+}
+
+// GetEmail implements registration.User.
+func (a *Account) GetEmail() string { return a.Email }
+
+// GetRegistration implements registration.User.
+func (a *Account) GetRegistration() *registration.Resource { return a.Registration }
+
+// GetPrivateKey implements registration.User.
+func (a *Account) GetPrivateKey() crypto.PrivateKey { return a.key }
+
+// NewAccount generates a fresh ACME account key (P-256) for email.
+func NewAccount(email string) (*Account, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Account{Email: email, key: key}, nil
+}
+
+// Config controls how a Manager provisions and renews certificates.
+type Config struct {
+	CADirURL     string        // ACME directory URL, e.g. Let's Encrypt production or staging
+	Domains      []string      // Domain(s) to request the certificate for
+	Challenge    string        // "http-01" or "dns-01"
+	DNSProvider  Provider      // Required if Challenge == "dns-01"
+	RenewBefore  time.Duration // Renew when less than this much validity remains (default 30 days)
+	MustStaple   bool          // Request the OCSP must-staple extension on the leaf certificate
+	HTTPProvider challenge.Provider
+}
+
+// Manager owns an ACME account and keeps a certificate current, notifying a Store of each
+// newly issued certificate so it can be published (e.g. folded into GenesisParameters).
+type Manager struct {
+	cfg     Config
+	client  *lego.Client
+	account *Account
+	store   *CertStore
+}
+
+// New creates a Manager, registers the account with the CA if it is not already registered,
+// and configures the requested challenge provider.
+func New(cfg Config, account *Account, store *CertStore) (*Manager, error) {
+	if cfg.RenewBefore <= 0 {
+		cfg.RenewBefore = 30 * 24 * time.Hour
+	}
+	legoCfg := lego.NewConfig(account)
+	legoCfg.CADirURL = cfg.CADirURL
+	legoCfg.Certificate.KeyType = certificate.EC256
+
+	client, err := lego.NewClient(legoCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.Challenge {
+	case "dns-01":
+		if cfg.DNSProvider == nil {
+			return nil, errors.New("dns-01 challenge requires a DNS Provider")
+		}
+		if err := client.Challenge.SetDNS01Provider(cfg.DNSProvider); err != nil {
+			return nil, err
+		}
+	default:
+		if cfg.HTTPProvider != nil {
+			if err := client.Challenge.SetHTTP01Provider(cfg.HTTPProvider); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if account.Registration == nil {
+		reg, err := client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
+		if err != nil {
+			return nil, err
+		}
+		account.Registration = reg
+	}
+
+	return &Manager{cfg: cfg, client: client, account: account, store: store}, nil
+}
+
+// Obtain requests a fresh certificate, overwriting whatever is currently in the store.
+func (m *Manager) Obtain() error {
+	req := certificate.ObtainRequest{
+		Domains:    m.cfg.Domains,
+		Bundle:     true,
+		MustStaple: m.cfg.MustStaple,
+	}
+	cert, err := m.client.Certificate.Obtain(req)
+	if err != nil {
+		return err
+	}
+	return m.store.Put(cert)
+}
+
+// RenewIfNeeded obtains a new certificate if the current one expires within RenewBefore (or
+// none exists yet), keeping the old certificate readable via CertStore.Previous until the new
+// one's validity has fully taken over so in-flight records signed by it still verify.
+func (m *Manager) RenewIfNeeded() (bool, error) {
+	cur, err := m.store.Current()
+	if err != nil {
+		if errors.Is(err, ErrNoCertificate) {
+			return true, m.Obtain()
+		}
+		return false, err
+	}
+	if time.Until(cur.Leaf.NotAfter) > m.cfg.RenewBefore {
+		return false, nil
+	}
+	res, err := m.client.Certificate.Renew(certificate.Resource{
+		Domain:      cur.Domain,
+		Certificate: cur.Certificate,
+		PrivateKey:  cur.PrivateKey,
+	}, true, m.cfg.MustStaple, "")
+	if err != nil {
+		return false, err
+	}
+	return true, m.store.Put(res)
+}
+
+// RunRenewalLoop checks RenewIfNeeded on the given interval until stop is closed. Each successful renewal invokes onRenew with the new DER-encoded leaf so the
+// node can post a GenesisParameters.Update amendment.
+func (m *Manager) RunRenewalLoop(interval time.Duration, stop <-chan struct{}, onRenew func(leafDER []byte)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			renewed, err := m.RenewIfNeeded()
+			if err == nil && renewed {
+				if cur, err := m.store.Current(); err == nil && onRenew != nil {
+					onRenew(cur.Leaf.Raw)
+				}
+			}
+		}
+	}
+}
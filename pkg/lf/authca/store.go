@@ -0,0 +1,85 @@
+/*
+ * LF: Global Fully Replicated Key/Value Store
+ * Copyright (C) 2018-2019  ZeroTier, Inc.  https://www.zerotier.com/
+ *
+ * Licensed under the terms of the MIT license (see LICENSE.txt).
+ */
+
+package authca
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"sync"
+
+	"github.com/go-acme/lego/v4/certificate"
+)
+
+// StoredCert pairs a lego certificate.Resource with its parsed leaf for convenience.
+type StoredCert struct {
+	certificate.Resource
+	Leaf *x509.Certificate
+}
+
+// CertStore holds the current and immediately-previous certificate so that records signed
+// during a renewal window remain verifiable against either one while the overlap lasts.
+type CertStore struct {
+	mu       sync.RWMutex
+	current  *StoredCert
+	previous *StoredCert
+}
+
+// NewCertStore returns an empty CertStore.
+func NewCertStore() *CertStore { return &CertStore{} }
+
+// Put installs res as the current certificate, demoting whatever was current to previous.
+func (s *CertStore) Put(res *certificate.Resource) error {
+	block, _ := tls.X509KeyPair(res.Certificate, res.PrivateKey)
+	var leaf *x509.Certificate
+	if len(block.Certificate) > 0 {
+		parsed, err := x509.ParseCertificate(block.Certificate[0])
+		if err != nil {
+			return err
+		}
+		leaf = parsed
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.previous = s.current
+	s.current = &StoredCert{Resource: *res, Leaf: leaf}
+	return nil
+}
+
+// Current returns the active certificate, or ErrNoCertificate if none has been issued.
+func (s *CertStore) Current() (*StoredCert, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.current == nil {
+		return nil, ErrNoCertificate
+	}
+	return s.current, nil
+}
+
+// Previous returns the certificate that was active before the most recent renewal, if any.
+// Verifiers should accept signatures from both Current and Previous during a renewal window.
+func (s *CertStore) Previous() *StoredCert {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.previous
+}
+
+// ValidDER returns the DER bytes of every certificate that should currently be accepted as a
+// signer, suitable for concatenation into GenesisParameters.AuthCertificates.
+func (s *CertStore) ValidDER() [][]byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out [][]byte
+	if s.current != nil && s.current.Leaf != nil {
+		out = append(out, s.current.Leaf.Raw)
+	}
+	if s.previous != nil && s.previous.Leaf != nil {
+		out = append(out, s.previous.Leaf.Raw)
+	}
+	return out
+}
@@ -0,0 +1,62 @@
+/*
+ * LF: Global Fully Replicated Key/Value Store
+ * Copyright (C) 2018-2019  ZeroTier, Inc.  https://www.zerotier.com/
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ *
+ * --
+ *
+ * You can be released from the requirements of the license by purchasing
+ * a commercial license. Buying such a license is mandatory as soon as you
+ * develop commercial closed-source software that incorporates or links
+ * directly against ZeroTier software without disclosing the source code
+ * of your own application.
+ */
+
+package lf
+
+import (
+	"time"
+
+	"github.com/pixelkaiser/lf/pkg/lf/authca"
+)
+
+// AuthCARenewalLoop runs mgr's ACME renewal loop in the background and, on every successful
+// renewal, posts a GenesisParameters amendment record updating AuthCertificates to the store's
+// currently-valid certificate chain. This is the call path that was missing entirely: without
+// it, authca.Manager could renew a certificate but nothing ever told the network about it.
+// The amendment is only honored if "authcertificates" is present in the genesis record's
+// AmendableFields (see GenesisParameters.Update), same as any other amendment. It stops when
+// stop is closed, mirroring Manager.RunRenewalLoop's own lifecycle.
+func AuthCARenewalLoop(n *Node, mgr *authca.Manager, store *authca.CertStore, genesisOwner *Owner, interval time.Duration, stop <-chan struct{}) {
+	mgr.RunRenewalLoop(interval, stop, func(_ []byte) {
+		_ = postAuthCertificateAmendment(n, genesisOwner, store.ValidDER())
+	})
+}
+
+// postAuthCertificateAmendment builds and commits a genesis amendment record updating
+// AuthCertificates to der, signed by genesisOwner. Factored out of AuthCARenewalLoop so the
+// amendment's shape can be exercised without a live ACME round trip.
+func postAuthCertificateAmendment(n *Node, genesisOwner *Owner, der [][]byte) error {
+	value, err := AuthCertificatesAmendmentJSON(der)
+	if err != nil {
+		return err
+	}
+	wg := NewWharrgarblr(RecordDefaultWharrgarblMemory, 0)
+	rec, err := NewRecord(RecordTypeGenesis, value, nil, nil, nil, nil, nil, TimeSec(), wg, genesisOwner)
+	if err != nil {
+		return err
+	}
+	return n.addRecord(rec)
+}
@@ -0,0 +1,226 @@
+/*
+ * LF: Global Fully Replicated Key/Value Store
+ * Copyright (C) 2018-2019  ZeroTier, Inc.  https://www.zerotier.com/
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ *
+ * --
+ *
+ * You can be released from the requirements of the license by purchasing
+ * a commercial license. Buying such a license is mandatory as soon as you
+ * develop commercial closed-source software that incorporates or links
+ * directly against ZeroTier software without disclosing the source code
+ * of your own application.
+ */
+
+package lf
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"runtime/pprof"
+	"sync"
+)
+
+// logRingBufferSize is the number of recent log lines retained when the ring buffer is enabled.
+const logRingBufferSize = 4096
+
+// logRingBuffer is an opt-in in-memory sink for the last N log lines, used only to populate
+// /bugreport bundles. It costs nothing when disabled (the Node simply never writes to it).
+type logRingBuffer struct {
+	mu    sync.Mutex
+	lines []string
+	next  int
+	full  bool
+}
+
+func newLogRingBuffer() *logRingBuffer {
+	return &logRingBuffer{lines: make([]string, logRingBufferSize)}
+}
+
+// Write appends line, overwriting the oldest entry once the buffer is full.
+func (b *logRingBuffer) Write(line string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lines[b.next] = line
+	b.next = (b.next + 1) % len(b.lines)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// Lines returns the retained lines in chronological order.
+func (b *logRingBuffer) Lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.full {
+		return append([]string(nil), b.lines[:b.next]...)
+	}
+	out := make([]string, 0, len(b.lines))
+	out = append(out, b.lines[b.next:]...)
+	out = append(out, b.lines[:b.next]...)
+	return out
+}
+
+// bugreportSecretPattern matches substrings that look like key material or bearer tokens so
+// they can be scrubbed from log lines and notes before archiving, even if something upstream
+// failed to avoid logging them in the first place.
+var bugreportSecretPattern = regexp.MustCompile(`(?i)(private[_-]?key|secret|token|password)\s*[:=]\s*\S+`)
+
+func scrubSecrets(s string) string {
+	return bugreportSecretPattern.ReplaceAllString(s, "$1=[REDACTED]")
+}
+
+// generateCorrelationID returns a short random hex ID for a bug report, returned to the caller
+// and embedded in the archive filename so it can be quoted when filing issues.
+func generateCorrelationID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func tarAddFile(tw *tar.Writer, name string, data []byte) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: TimeSecToTime(TimeSec())}); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// buildBugreportArchive assembles the diagnostic tarball described by the /bugreport handler.
+func buildBugreportArchive(n *Node, note string) ([]byte, string, error) {
+	correlationID := generateCorrelationID()
+
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+
+	rc, ds := n.db.stats()
+	var status APIStatus
+	status.Software = SoftwareName
+	status.Version[0] = VersionMajor
+	status.Version[1] = VersionMinor
+	status.Version[2] = VersionRevision
+	status.Version[3] = VersionBuild
+	status.MinAPIVersion = APIVersion
+	status.MaxAPIVersion = APIVersion
+	status.Uptime = n.startTime
+	status.ConnectedPeers = apiMakePeerArray(n)
+	status.DBRecordCount = rc
+	status.DBSize = ds
+	statusJSON, err := json.MarshalIndent(&status, "", "  ")
+	if err != nil {
+		return nil, "", err
+	}
+	if err := tarAddFile(tw, "status.json", statusJSON); err != nil {
+		return nil, "", err
+	}
+
+	if n.logRing != nil {
+		var lb bytes.Buffer
+		for _, line := range n.logRing.Lines() {
+			lb.WriteString(scrubSecrets(line))
+			lb.WriteByte('\n')
+		}
+		if err := tarAddFile(tw, "log.txt", lb.Bytes()); err != nil {
+			return nil, "", err
+		}
+	}
+
+	var goroutines bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&goroutines, 1); err == nil {
+		if err := tarAddFile(tw, "goroutine.pprof.txt", goroutines.Bytes()); err != nil {
+			return nil, "", err
+		}
+	}
+	var heap bytes.Buffer
+	if err := pprof.Lookup("heap").WriteTo(&heap, 0); err == nil {
+		if err := tarAddFile(tw, "heap.pprof", heap.Bytes()); err != nil {
+			return nil, "", err
+		}
+	}
+
+	peersJSON, err := json.MarshalIndent(apiMakePeerArray(n), "", "  ")
+	if err == nil {
+		if err := tarAddFile(tw, "peers.json", peersJSON); err != nil {
+			return nil, "", err
+		}
+	}
+
+	dbStatsJSON, _ := json.MarshalIndent(&struct {
+		RecordCount uint64 `json:"recordCount"`
+		SizeBytes   uint64 `json:"sizeBytes"`
+	}{RecordCount: rc, SizeBytes: ds}, "", "  ")
+	if err := tarAddFile(tw, "db_stats.json", dbStatsJSON); err != nil {
+		return nil, "", err
+	}
+
+	if len(note) > 0 {
+		if err := tarAddFile(tw, "note.txt", []byte(scrubSecrets(note)+"\n")); err != nil {
+			return nil, "", err
+		}
+	}
+
+	meta, _ := json.MarshalIndent(&struct {
+		CorrelationID string `json:"correlationId"`
+		GeneratedAt   uint64 `json:"generatedAt"`
+	}{CorrelationID: correlationID, GeneratedAt: TimeMs()}, "", "  ")
+	if err := tarAddFile(tw, "meta.json", meta); err != nil {
+		return nil, "", err
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, "", err
+	}
+	if err := gzw.Close(); err != nil {
+		return nil, "", err
+	}
+
+	return buf.Bytes(), correlationID, nil
+}
+
+// apiAddBugreportHandler registers the trusted-only /bugreport handler.
+func apiAddBugreportHandler(smux *http.ServeMux, n *Node) {
+	smux.HandleFunc("/bugreport", func(out http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodPost {
+			apiSendJSON(out, req, http.StatusMethodNotAllowed, &APIError{Code: http.StatusMethodNotAllowed, Message: req.Method + " not supported for this path"})
+			return
+		}
+		if !apiIsTrusted(n, req) {
+			apiSendJSON(out, req, http.StatusForbidden, &APIError{Code: http.StatusForbidden, Message: "bug reports may only be generated from trusted hosts"})
+			return
+		}
+
+		note := req.URL.Query().Get("note")
+		archive, correlationID, err := buildBugreportArchive(n, note)
+		if err != nil {
+			apiSendJSON(out, req, http.StatusInternalServerError, &APIError{Code: http.StatusInternalServerError, Message: err.Error()})
+			return
+		}
+
+		filename := fmt.Sprintf("lf-bugreport-%s-%d.tar.gz", correlationID, TimeSec())
+		out.Header().Set("Content-Type", "application/gzip")
+		out.Header().Set("Content-Disposition", "attachment; filename=\""+filename+"\"")
+		out.Header().Set("X-LF-Correlation-ID", correlationID)
+		out.WriteHeader(http.StatusOK)
+		_, _ = out.Write(archive)
+	})
+}
@@ -0,0 +1,199 @@
+/*
+ * LF: Global Fully Replicated Key/Value Store
+ * Copyright (C) 2018-2019  ZeroTier, Inc.  https://www.zerotier.com/
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ *
+ * --
+ *
+ * You can be released from the requirements of the license by purchasing
+ * a commercial license. Buying such a license is mandatory as soon as you
+ * develop commercial closed-source software that incorporates or links
+ * directly against ZeroTier software without disclosing the source code
+ * of your own application.
+ */
+
+package lf
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Capability is a single named privilege a CapabilityToken can grant.
+type Capability string
+
+// Capabilities understood by the HTTP API. CapPutSelector is a prefix capability: a grant of
+// "cap:put-selector:alice/" authorizes puts whose first selector starts with "alice/".
+const (
+	CapPut         Capability = "cap:put"
+	CapPutSelector Capability = "cap:put-selector:" // prefix; compare with HasPrefix
+	CapConnect     Capability = "cap:connect"
+	CapStatusPeers Capability = "cap:status-peers"
+	CapRecordBuild Capability = "cap:record-build"
+)
+
+// capabilityTokenPayload is the JSON structure signed inside a CapabilityToken.
+type capabilityTokenPayload struct {
+	Grants  []string `json:"g"`
+	Expires uint64   `json:"exp"` // Unix seconds; 0 means no expiration
+}
+
+// CapabilityAuthority mints and verifies bearer tokens carried in an
+// "Authorization: LF <token>" header. Each node has exactly one authority, keyed by an
+// HMAC-SHA256 key persisted alongside the node's identity so tokens survive a restart.
+type CapabilityAuthority struct {
+	key []byte
+
+	mu      sync.RWMutex
+	revoked map[string]bool // keyed by the token string itself
+}
+
+// NewCapabilityAuthority creates an authority from a persisted HMAC key. Generate key with
+// crypto/rand once per node and store it next to the node's identity secret.
+func NewCapabilityAuthority(key []byte) *CapabilityAuthority {
+	return &CapabilityAuthority{key: key, revoked: make(map[string]bool)}
+}
+
+// Mint issues a new bearer token carrying grants, optionally expiring at unixExpires (0 = never).
+func (ca *CapabilityAuthority) Mint(grants []string, unixExpires uint64) (string, error) {
+	payload, err := json.Marshal(&capabilityTokenPayload{Grants: grants, Expires: unixExpires})
+	if err != nil {
+		return "", err
+	}
+	payloadB64 := base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, ca.key)
+	mac.Write([]byte(payloadB64))
+	sig := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	return payloadB64 + "." + sig, nil
+}
+
+// Revoke blacklists token so it is rejected by Verify even if it has not yet expired.
+func (ca *CapabilityAuthority) Revoke(token string) {
+	ca.mu.Lock()
+	ca.revoked[token] = true
+	ca.mu.Unlock()
+}
+
+// Verify checks token's signature, expiration, and revocation status, and returns its grants.
+func (ca *CapabilityAuthority) Verify(token string) ([]Capability, error) {
+	ca.mu.RLock()
+	revoked := ca.revoked[token]
+	ca.mu.RUnlock()
+	if revoked {
+		return nil, fmt.Errorf("token has been revoked")
+	}
+
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("malformed token")
+	}
+	payloadB64, sig := parts[0], parts[1]
+
+	mac := hmac.New(sha256.New, ca.key)
+	mac.Write([]byte(payloadB64))
+	expectedSig := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	if subtle.ConstantTimeCompare([]byte(sig), []byte(expectedSig)) != 1 {
+		return nil, fmt.Errorf("invalid token signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(payloadB64)
+	if err != nil {
+		return nil, err
+	}
+	var tp capabilityTokenPayload
+	if err := json.Unmarshal(payload, &tp); err != nil {
+		return nil, err
+	}
+	if tp.Expires != 0 && TimeSec() >= tp.Expires {
+		return nil, fmt.Errorf("token has expired")
+	}
+
+	grants := make([]Capability, 0, len(tp.Grants))
+	for _, g := range tp.Grants {
+		grants = append(grants, Capability(g))
+	}
+	return grants, nil
+}
+
+// grantSatisfies returns true if grant authorizes required: an exact match, a full CapPut
+// grant satisfying any CapPutSelector-prefixed requirement (a broad put grant also covers
+// the narrower selector-scoped case), or a matching cap:put-selector:<prefix> grant for a
+// CapPutSelector-prefixed requirement.
+func grantSatisfies(grant, required Capability) bool {
+	if grant == required {
+		return true
+	}
+	if strings.HasPrefix(string(required), string(CapPutSelector)) {
+		if grant == CapPut {
+			return true
+		}
+		if strings.HasPrefix(string(grant), string(CapPutSelector)) {
+			return strings.HasPrefix(string(required)[len(CapPutSelector):], string(grant)[len(CapPutSelector):])
+		}
+	}
+	return false
+}
+
+// requiredPutCapability returns the capability a /p submission must present: a selector-scoped
+// grant if the put carries a plain-text first selector, otherwise the general cap:put grant.
+func requiredPutCapability(put *APIPut) Capability {
+	if len(put.Selectors[0]) > 0 {
+		return CapPutSelector + Capability(put.Selectors[0])
+	}
+	return CapPut
+}
+
+// apiAuthorize extracts and verifies the bearer token from req's Authorization header against
+// n's capability authority and reports whether it grants every capability in required. It
+// supersedes the old loopback-only apiIsTrusted check for endpoints that support fine-grained
+// delegation; apiIsTrusted is still used for node-local admin operations like /auth/mint.
+func apiAuthorize(n *Node, req *http.Request, required ...Capability) bool {
+	if n.capAuth == nil {
+		// No authority configured: fall back to the legacy trusted-host behavior so existing
+		// single-node deployments keep working without provisioning tokens.
+		return apiIsTrusted(n, req)
+	}
+
+	auth := req.Header.Get("Authorization")
+	const prefix = "LF "
+	if !strings.HasPrefix(auth, prefix) {
+		return false
+	}
+	grants, err := n.capAuth.Verify(strings.TrimPrefix(auth, prefix))
+	if err != nil {
+		return false
+	}
+
+	for _, rc := range required {
+		ok := false
+		for _, g := range grants {
+			if grantSatisfies(g, rc) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,124 @@
+/*
+ * LF: Global Fully Replicated Key/Value Store
+ * Copyright (C) 2018-2019  ZeroTier, Inc.  https://www.zerotier.com/
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ *
+ * --
+ *
+ * You can be released from the requirements of the license by purchasing
+ * a commercial license. Buying such a license is mandatory as soon as you
+ * develop commercial closed-source software that incorporates or links
+ * directly against ZeroTier software without disclosing the source code
+ * of your own application.
+ */
+
+package lf
+
+import "testing"
+
+func TestCapabilityAuthorityExpiry(t *testing.T) {
+	ca := NewCapabilityAuthority([]byte("test key"))
+
+	tok, err := ca.Mint([]string{string(CapPut)}, 0)
+	if err != nil {
+		t.Fatalf("Mint: %v", err)
+	}
+	if _, err := ca.Verify(tok); err != nil {
+		t.Fatalf("Verify of a non-expiring token: %v", err)
+	}
+
+	expired, err := ca.Mint([]string{string(CapPut)}, TimeSec()-60)
+	if err != nil {
+		t.Fatalf("Mint: %v", err)
+	}
+	if _, err := ca.Verify(expired); err == nil {
+		t.Fatal("expected Verify to reject an already-expired token")
+	}
+
+	notYetExpired, err := ca.Mint([]string{string(CapPut)}, TimeSec()+60)
+	if err != nil {
+		t.Fatalf("Mint: %v", err)
+	}
+	if _, err := ca.Verify(notYetExpired); err != nil {
+		t.Fatalf("Verify of a token expiring in the future: %v", err)
+	}
+}
+
+func TestCapabilityAuthorityRevocation(t *testing.T) {
+	ca := NewCapabilityAuthority([]byte("test key"))
+
+	tok, err := ca.Mint([]string{string(CapPut)}, 0)
+	if err != nil {
+		t.Fatalf("Mint: %v", err)
+	}
+	if _, err := ca.Verify(tok); err != nil {
+		t.Fatalf("Verify before revocation: %v", err)
+	}
+
+	ca.Revoke(tok)
+	if _, err := ca.Verify(tok); err == nil {
+		t.Fatal("expected Verify to reject a revoked token")
+	}
+}
+
+func TestCapabilityAuthorityBadSignature(t *testing.T) {
+	ca := NewCapabilityAuthority([]byte("test key"))
+	other := NewCapabilityAuthority([]byte("a different key"))
+
+	tok, err := other.Mint([]string{string(CapPut)}, 0)
+	if err != nil {
+		t.Fatalf("Mint: %v", err)
+	}
+	if _, err := ca.Verify(tok); err == nil {
+		t.Fatal("expected Verify to reject a token signed with a different key")
+	}
+}
+
+func TestGrantSatisfiesSelectorPrefix(t *testing.T) {
+	cases := []struct {
+		name     string
+		grant    Capability
+		required Capability
+		want     bool
+	}{
+		{"exact match", CapPut, CapPut, true},
+		{"mismatched exact", CapPut, CapConnect, false},
+		{"selector grant covers exact path", CapPutSelector + "alice/", CapPutSelector + "alice/posts/1", true},
+		{"selector grant covers itself", CapPutSelector + "alice/", CapPutSelector + "alice/", true},
+		{"selector grant does not cover sibling prefix", CapPutSelector + "alice/", CapPutSelector + "bob/posts/1", false},
+		{"selector grant does not cover a shorter path", CapPutSelector + "alice/posts/", CapPutSelector + "alice/", false},
+		{"a full cap:put grant satisfies any selector-scoped requirement", CapPut, CapPutSelector + "alice/", true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := grantSatisfies(c.grant, c.required); got != c.want {
+				t.Fatalf("grantSatisfies(%q, %q) = %v, want %v", c.grant, c.required, got, c.want)
+			}
+		})
+	}
+}
+
+func TestRequiredPutCapability(t *testing.T) {
+	generic := &APIPut{}
+	if got := requiredPutCapability(generic); got != CapPut {
+		t.Fatalf("requiredPutCapability with no selector = %q, want %q", got, CapPut)
+	}
+
+	scoped := &APIPut{Selectors: [2][]byte{[]byte("alice/posts/1"), nil}}
+	want := CapPutSelector + Capability("alice/posts/1")
+	if got := requiredPutCapability(scoped); got != want {
+		t.Fatalf("requiredPutCapability with a selector = %q, want %q", got, want)
+	}
+}
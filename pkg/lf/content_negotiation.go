@@ -0,0 +1,130 @@
+/*
+ * LF: Global Fully Replicated Key/Value Store
+ * Copyright (C) 2018-2019  ZeroTier, Inc.  https://www.zerotier.com/
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ *
+ * --
+ *
+ * You can be released from the requirements of the license by purchasing
+ * a commercial license. Buying such a license is mandatory as soon as you
+ * develop commercial closed-source software that incorporates or links
+ * directly against ZeroTier software without disclosing the source code
+ * of your own application.
+ */
+
+package lf
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack"
+)
+
+// apiRenderer encodes obj to out in some wire format and sets the appropriate headers. It
+// generalizes the ad hoc JSON-or-msgpack branch apiSendJSON used to hard-code, so additional
+// formats (and the raw binary record format) can be added without touching every handler.
+type apiRenderer interface {
+	ContentType() string
+	Render(w http.ResponseWriter, obj interface{}, status int) error
+}
+
+type jsonRenderer struct{}
+
+func (jsonRenderer) ContentType() string { return "application/json" }
+func (jsonRenderer) Render(w http.ResponseWriter, obj interface{}, status int) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(obj)
+}
+
+type msgpackRenderer struct{ contentType string }
+
+func (r msgpackRenderer) ContentType() string { return r.contentType }
+func (r msgpackRenderer) Render(w http.ResponseWriter, obj interface{}, status int) error {
+	w.Header().Set("Content-Type", r.contentType)
+	w.WriteHeader(status)
+	return msgpack.NewEncoder(w).Encode(obj)
+}
+
+type cborRenderer struct{}
+
+func (cborRenderer) ContentType() string { return "application/cbor" }
+func (cborRenderer) Render(w http.ResponseWriter, obj interface{}, status int) error {
+	w.Header().Set("Content-Type", "application/cbor")
+	w.WriteHeader(status)
+	enc := cbor.NewEncoder(w)
+	return enc.Encode(obj)
+}
+
+// rawRecordRenderer writes obj directly as raw bytes with no enclosing envelope. It is only
+// meaningful for objects that are already []byte or implement a Bytes() []byte method (such
+// as *Record); anything else is an apiRenderer usage error on the caller's part.
+type rawRecordRenderer struct{}
+
+func (rawRecordRenderer) ContentType() string { return "application/x-lf-record" }
+func (rawRecordRenderer) Render(w http.ResponseWriter, obj interface{}, status int) error {
+	w.Header().Set("Content-Type", "application/x-lf-record")
+	w.WriteHeader(status)
+	switch v := obj.(type) {
+	case []byte:
+		_, err := w.Write(v)
+		return err
+	case interface{ Bytes() []byte }:
+		_, err := w.Write(v.Bytes())
+		return err
+	default:
+		return json.NewEncoder(w).Encode(obj)
+	}
+}
+
+// apiNegotiateRenderer picks a renderer for req's Accept header, defaulting to JSON. This is
+// used by handlers that want more than the binary JSON/msgpack choice apiSendJSON makes.
+func apiNegotiateRenderer(req *http.Request) apiRenderer {
+	accept, haveAccept := req.Header["Accept"]
+	if !haveAccept {
+		return jsonRenderer{}
+	}
+	for i := range accept {
+		for _, asp := range strings.FieldsFunc(accept[i], func(r rune) bool { return r == ',' || r == ';' || r == ' ' || r == '\t' }) {
+			asp = strings.TrimSpace(asp)
+			switch {
+			case strings.Contains(asp, "msgpack"):
+				return msgpackRenderer{contentType: asp}
+			case strings.Contains(asp, "cbor"):
+				return cborRenderer{}
+			case strings.Contains(asp, "x-lf-record"):
+				return rawRecordRenderer{}
+			}
+		}
+	}
+	return jsonRenderer{}
+}
+
+// kExtContentTypes maps a /k/<key>.<ext> extension to the Content-Type returned for that
+// extension's raw value. Extensions not in this table (other than json/msgpack, handled
+// separately) result in a 404.
+var kExtContentTypes = map[string]string{
+	"html": "text/html; charset=utf-8",
+	"js":   "application/javascript",
+	"png":  "image/png",
+	"gif":  "image/gif",
+	"jpg":  "image/jpeg",
+	"xml":  "application/xml",
+	"css":  "text/css; charset=utf-8",
+	"txt":  "text/plain; charset=utf-8",
+}
@@ -0,0 +1,142 @@
+/*
+ * LF: Global Fully Replicated Key/Value Store
+ * Copyright (C) 2018-2019  ZeroTier, Inc.  https://www.zerotier.com/
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ *
+ * --
+ *
+ * You can be released from the requirements of the license by purchasing
+ * a commercial license. Buying such a license is mandatory as soon as you
+ * develop commercial closed-source software that incorporates or links
+ * directly against ZeroTier software without disclosing the source code
+ * of your own application.
+ */
+
+package lf
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack"
+)
+
+func TestAPINegotiateRenderer(t *testing.T) {
+	cases := []struct {
+		name                string
+		accept              string
+		wantContentType     string
+		wantContentTypeExac bool // if true, compare ContentType() exactly rather than by prefix
+	}{
+		{"no Accept header defaults to JSON", "", "application/json", true},
+		{"unrecognized Accept defaults to JSON", "text/plain", "application/json", true},
+		{"msgpack", "application/x-msgpack", "application/x-msgpack", true},
+		{"msgpack with other acceptable types listed first", "text/html, application/msgpack;q=0.9", "application/msgpack", true},
+		{"cbor", "application/cbor", "application/cbor", true},
+		{"raw record", "application/x-lf-record", "application/x-lf-record", true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if len(c.accept) > 0 {
+				req.Header.Set("Accept", c.accept)
+			}
+			r := apiNegotiateRenderer(req)
+			got := r.ContentType()
+			if (c.wantContentTypeExac && got != c.wantContentType) || (!c.wantContentTypeExac && !strings.HasPrefix(got, c.wantContentType)) {
+				t.Fatalf("ContentType() = %q, want %q", got, c.wantContentType)
+			}
+		})
+	}
+}
+
+func TestAPIRenderersEncodeBody(t *testing.T) {
+	type payload struct {
+		A int    `json:"a"`
+		B string `json:"b"`
+	}
+	obj := payload{A: 1, B: "hi"}
+
+	t.Run("json", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		if err := (jsonRenderer{}).Render(rec, obj, 200); err != nil {
+			t.Fatalf("Render: %v", err)
+		}
+		if !strings.Contains(rec.Body.String(), `"b":"hi"`) {
+			t.Fatalf("unexpected JSON body: %s", rec.Body.String())
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Fatalf("Content-Type header = %q, want application/json", ct)
+		}
+	})
+
+	t.Run("msgpack", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		if err := (msgpackRenderer{contentType: "application/x-msgpack"}).Render(rec, obj, 200); err != nil {
+			t.Fatalf("Render: %v", err)
+		}
+		var decoded payload
+		if err := msgpack.Unmarshal(rec.Body.Bytes(), &decoded); err != nil {
+			t.Fatalf("msgpack.Unmarshal: %v", err)
+		}
+		if decoded != obj {
+			t.Fatalf("decoded %+v, want %+v", decoded, obj)
+		}
+	})
+
+	t.Run("cbor", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		if err := (cborRenderer{}).Render(rec, obj, 200); err != nil {
+			t.Fatalf("Render: %v", err)
+		}
+		var decoded payload
+		if err := cbor.Unmarshal(rec.Body.Bytes(), &decoded); err != nil {
+			t.Fatalf("cbor.Unmarshal: %v", err)
+		}
+		if decoded != obj {
+			t.Fatalf("decoded %+v, want %+v", decoded, obj)
+		}
+	})
+
+	t.Run("raw record bytes", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		raw := []byte{0x01, 0x02, 0x03}
+		if err := (rawRecordRenderer{}).Render(rec, raw, 200); err != nil {
+			t.Fatalf("Render: %v", err)
+		}
+		if rec.Body.String() != string(raw) {
+			t.Fatalf("raw record body = %v, want %v", rec.Body.Bytes(), raw)
+		}
+	})
+
+	t.Run("raw record via Bytes()", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		if err := (rawRecordRenderer{}).Render(rec, bytesLike{data: []byte("abc")}, 200); err != nil {
+			t.Fatalf("Render: %v", err)
+		}
+		if rec.Body.String() != "abc" {
+			t.Fatalf("raw record body = %q, want %q", rec.Body.String(), "abc")
+		}
+	})
+}
+
+// bytesLike is a minimal stand-in for *Record, which rawRecordRenderer also special-cases via
+// its Bytes() []byte method.
+type bytesLike struct{ data []byte }
+
+func (b bytesLike) Bytes() []byte { return b.data }
@@ -0,0 +1,194 @@
+/*
+ * LF: Global Fully Replicated Key/Value Store
+ * Copyright (C) 2018-2019  ZeroTier, Inc.  https://www.zerotier.com/
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ *
+ * --
+ *
+ * You can be released from the requirements of the license by purchasing
+ * a commercial license. Buying such a license is mandatory as soon as you
+ * develop commercial closed-source software that incorporates or links
+ * directly against ZeroTier software without disclosing the source code
+ * of your own application.
+ */
+
+package lf
+
+import (
+	"bytes"
+	"sync"
+)
+
+// db is a minimal in-memory record store. The production storage and replication engine
+// (disk-backed, indexed by owner/selector/time) lives outside this package snapshot; this
+// gives the HTTP/RPC surface above it something real to read from and write to rather than a
+// Node field with no backing implementation at all.
+type db struct {
+	mu      sync.RWMutex
+	records map[[32]byte]*Record
+	genesis *GenesisParameters
+}
+
+func newDB() *db {
+	return &db{records: make(map[[32]byte]*Record)}
+}
+
+func (d *db) addRecord(rec *Record) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.records[rec.Hash()] = rec
+	return nil
+}
+
+func (d *db) getByHash(h [32]byte) (*Record, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.records[h], nil
+}
+
+// getBestByKey returns the first record that decrypts under key. The real store picks the
+// highest-weighted record among all that do; without a weight/PoW model at this layer, the
+// first match is returned instead.
+func (d *db) getBestByKey(key []byte) (*Record, []byte, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, rec := range d.records {
+		if value, err := rec.Decrypt(key); err == nil {
+			return rec, value, nil
+		}
+	}
+	return nil, nil, nil
+}
+
+func (d *db) getByQuery(get *APIGet) ([]APIRecordDetail, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var out []APIRecordDetail
+	for _, rec := range d.records {
+		if !recordMatchesGet(get, rec) {
+			continue
+		}
+		out = append(out, APIRecordDetail{Record: *rec})
+		if get.MaxResults > 0 && uint(len(out)) >= get.MaxResults {
+			break
+		}
+	}
+	return out, nil
+}
+
+func recordMatchesGet(get *APIGet, rec *Record) bool {
+	if len(get.Owner) > 0 && !bytes.Equal(get.Owner, rec.Owner) {
+		return false
+	}
+	if len(get.Key) > 0 {
+		if _, err := rec.Decrypt(get.Key); err != nil {
+			return false
+		}
+	} else if len(get.ID) > 0 && !bytes.Equal(get.ID, rec.ID()) {
+		return false
+	}
+	for i := range get.SelectorIDs {
+		if len(get.SelectorIDs[i]) > 0 {
+			if i >= len(rec.Selectors) || !bytes.Equal(get.SelectorIDs[i], rec.Selectors[i].ID()) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// getByQueryRange is getByQuery's counterpart for Query's selector ranges rather than exact
+// selector IDs.
+func (d *db) getByQueryRange(q *Query) ([]APIRecordDetail, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var out []APIRecordDetail
+	for _, rec := range d.records {
+		if len(q.Owner) > 0 && !bytes.Equal(q.Owner, rec.Owner) {
+			continue
+		}
+		if !recordMatchesSelectorRanges(q.Selectors, rec) {
+			continue
+		}
+		out = append(out, APIRecordDetail{Record: *rec})
+		if q.MaxResults > 0 && uint(len(out)) >= q.MaxResults {
+			break
+		}
+	}
+	return out, nil
+}
+
+// recordMatchesSelectorRanges reports whether rec satisfies every selector range in ranges.
+// Only each selector's opaque ID() hash is available at this layer (not the raw ordinal the
+// real store indexes by), so a range can only be checked exactly (Low == High); a genuine
+// Low < High range matches unconditionally, deferring true ordinal-range filtering to the
+// production selector index.
+func recordMatchesSelectorRanges(ranges []QuerySelectorRange, rec *Record) bool {
+	for i := range ranges {
+		if i >= len(rec.Selectors) {
+			return false
+		}
+		if len(ranges[i].Low) > 0 && bytes.Equal(ranges[i].Low, ranges[i].High) {
+			if !bytes.Equal(ranges[i].Low, rec.Selectors[i].ID()) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (d *db) getLinks(count uint) ([][32]byte, int64, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if count == 0 {
+		count = 2
+	}
+	out := make([][32]byte, 0, count)
+	for h := range d.records {
+		if uint(len(out)) >= count {
+			break
+		}
+		out = append(out, h)
+	}
+	return out, int64(TimeSec()), nil
+}
+
+func (d *db) stats() (uint64, uint64) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var size uint64
+	for _, rec := range d.records {
+		size += uint64(len(rec.Bytes()))
+	}
+	return uint64(len(d.records)), size
+}
+
+func (d *db) genesisParameters() (*GenesisParameters, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.genesis == nil {
+		return &GenesisParameters{}, nil
+	}
+	return d.genesis, nil
+}
+
+// setGenesisParameters records gp as the parameters the genesisParameters accessor (used by
+// apiAuthorizeRecordOIDC, among others) returns. The production store derives this from the
+// network's actual genesis records; this lets it be set directly until that path exists here.
+func (d *db) setGenesisParameters(gp *GenesisParameters) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.genesis = gp
+}
@@ -31,20 +31,23 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+
+	"github.com/pixelkaiser/lf/pkg/lf/secrets"
 )
 
 // GenesisParameters is the payload (JSON encoded) of the first RecordMinLinks records in a global data store.
 type GenesisParameters struct {
-	Name                      string   `json:",omitempty"` // Name of this LF network / data store
-	Contact                   string   `json:",omitempty"` // Contact info for this network (may be empty)
-	Comment                   string   `json:",omitempty"` // Optional comment
-	AuthCertificates          Blob     `json:",omitempty"` // X.509 certificate(s) that can sign records to bypass work requirement
-	AuthRequired              bool     ``                  // If true a CA signature is required and simple proof of work is not accepted
-	LinkKey                   [32]byte ``                  // Static 32-byte key used to ensure that nodes in this network only connect to one another
-	RecordMinLinks            uint     ``                  // Minimum number of links required for non-genesis records
-	RecordMaxValueSize        uint     ``                  // Maximum size of record values
-	RecordMaxForwardTimeDrift uint     ``                  // Maximum number of seconds in the future a record can be timestamped
-	AmendableFields           []string `json:",omitempty"` // List of json field names that the genesis owner can change by posting non-empty records
+	Name                      string       `json:",omitempty"` // Name of this LF network / data store
+	Contact                   string       `json:",omitempty"` // Contact info for this network (may be empty)
+	Comment                   string       `json:",omitempty"` // Optional comment
+	AuthCertificates          Blob         `json:",omitempty"` // X.509 certificate(s) that can sign records to bypass work requirement
+	AuthRequired              bool         ``                  // If true a CA signature is required and simple proof of work is not accepted
+	LinkKey                   [32]byte     ``                  // Static 32-byte key used to ensure that nodes in this network only connect to one another
+	RecordMinLinks            uint         ``                  // Minimum number of links required for non-genesis records
+	RecordMaxValueSize        uint         ``                  // Maximum size of record values
+	RecordMaxForwardTimeDrift uint         ``                  // Maximum number of seconds in the future a record can be timestamped
+	OIDCIssuers               []OIDCIssuer `json:",omitempty"` // OIDC issuers whose ID tokens may authorize records in place of an AuthCertificates signature
+	AmendableFields           []string     `json:",omitempty"` // List of json field names that the genesis owner can change by posting non-empty records
 
 	certs       []*x509.Certificate
 	initialized bool
@@ -100,6 +103,8 @@ func (gp *GenesisParameters) Update(jsonValue []byte) error {
 				gp.RecordMaxValueSize = ngp.RecordMaxValueSize
 			case "recordmaxforwardtimedrift":
 				gp.RecordMaxForwardTimeDrift = ngp.RecordMaxForwardTimeDrift
+			case "oidcissuers":
+				gp.OIDCIssuers = ngp.OIDCIssuers
 			case "amendablefields":
 				gp.AmendableFields = ngp.AmendableFields
 			}
@@ -123,7 +128,7 @@ func (gp *GenesisParameters) SetAmendableFields(fields []string) error {
 		switch af {
 		case
 			"name", "contact", "comment", "authcertificates", "authrequired", "linkkey",
-			"recordminlinks", "recordmaxvaluesize", "recordmaxforwardtimedrift", "amendablefields":
+			"recordminlinks", "recordmaxvaluesize", "recordmaxforwardtimedrift", "oidcissuers", "amendablefields":
 			gp.AmendableFields = append(gp.AmendableFields, af)
 		default:
 			return fmt.Errorf("invalid amendable field name: %s", f)
@@ -132,6 +137,19 @@ func (gp *GenesisParameters) SetAmendableFields(fields []string) error {
 	return nil
 }
 
+// AuthCertificatesAmendmentJSON builds the JSON value for a genesis amendment record that
+// updates AuthCertificates to der (the concatenated DER bytes of one or more certificates).
+// The result is only accepted by Update if "authcertificates" is listed in AmendableFields.
+func AuthCertificatesAmendmentJSON(der [][]byte) ([]byte, error) {
+	var blob Blob
+	for _, d := range der {
+		blob = append(blob, d...)
+	}
+	return json.Marshal(&struct {
+		AuthCertificates Blob
+	}{AuthCertificates: blob})
+}
+
 // GetAuthCertificates returns the fully deserialized auth CAs in this parameter set.
 func (gp *GenesisParameters) GetAuthCertificates() ([]*x509.Certificate, error) {
 	if len(gp.certs) > 0 {
@@ -152,18 +170,41 @@ func (gp *GenesisParameters) GetAuthCertificates() ([]*x509.Certificate, error)
 // The number created is always sufficient to satisfy RecordMinLinks for subsequent records.
 // If RecordMinLinks is zero one record is created. The first genesis record will contain
 // the Genesis parameters in JSON format while subsequent records are empty.
+//
+// The genesis owner's private key is generated and immediately handed to an in-memory
+// secrets.Manager rather than being kept only as a bare field on the returned *Owner, so
+// this path and CreateGenesisRecordsFromSecretsManager share one code path for how the key
+// comes into being. Callers that want the key persisted (to disk or to Vault) should call
+// CreateGenesisRecordsFromSecretsManager directly with a durable backend instead of reading
+// genesisOwner.PrivateBytes() off the value this function returns.
 func CreateGenesisRecords(genesisOwnerType byte, genesisParameters *GenesisParameters) ([]*Record, *Owner, error) {
-	gpjson, err := json.Marshal(genesisParameters)
+	return CreateGenesisRecordsFromSecretsManager(genesisOwnerType, genesisParameters, secrets.NewMemoryManager(), "genesis")
+}
+
+// CreateGenesisRecordsFromSecretsManager builds the genesis record set using a genesis owner
+// key that is generated, stored into sm under ownerSecretName, and then loaded back out of sm
+// before signing, so the Owner that signs the records is the one read back from sm. The
+// freshly generated key passes through this function as a byte slice only on its way into sm.
+func CreateGenesisRecordsFromSecretsManager(genesisOwnerType byte, genesisParameters *GenesisParameters, sm secrets.Manager, ownerSecretName string) ([]*Record, *Owner, error) {
+	generatedOwner, err := NewOwner(genesisOwnerType)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := sm.SetSecret(ownerSecretName, generatedOwner.PrivateBytes()); err != nil {
+		return nil, nil, err
+	}
+	genesisOwner, err := LoadOwnerFromSecretsManager(sm, ownerSecretName)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	var records []*Record
-	var links [][32]byte
-	genesisOwner, err := NewOwner(genesisOwnerType)
+	gpjson, err := json.Marshal(genesisParameters)
 	if err != nil {
 		return nil, nil, err
 	}
+
+	var records []*Record
+	var links [][32]byte
 	now := TimeSec()
 
 	// Genesis records always carry PoW
@@ -189,3 +230,14 @@ func CreateGenesisRecords(genesisOwnerType byte, genesisParameters *GenesisParam
 
 	return records, genesisOwner, nil
 }
+
+// LoadOwnerFromSecretsManager loads a previously stored Owner private key by name. It is the
+// counterpart of CreateGenesisRecordsFromSecretsManager and of any other path that stores a
+// key via SetSecret.
+func LoadOwnerFromSecretsManager(sm secrets.Manager, secretName string) (*Owner, error) {
+	skBytes, err := sm.GetSecret(secretName)
+	if err != nil {
+		return nil, err
+	}
+	return NewOwnerFromPrivateBytes(skBytes)
+}
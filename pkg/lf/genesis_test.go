@@ -0,0 +1,71 @@
+/*
+ * LF: Global Fully Replicated Key/Value Store
+ * Copyright (C) 2018-2019  ZeroTier, Inc.  https://www.zerotier.com/
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ *
+ * --
+ *
+ * You can be released from the requirements of the license by purchasing
+ * a commercial license. Buying such a license is mandatory as soon as you
+ * develop commercial closed-source software that incorporates or links
+ * directly against ZeroTier software without disclosing the source code
+ * of your own application.
+ */
+
+package lf
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAuthCertificatesAmendmentJSON(t *testing.T) {
+	der := [][]byte{{1, 2, 3}, {4, 5, 6}}
+	amendment, err := AuthCertificatesAmendmentJSON(der)
+	if err != nil {
+		t.Fatalf("AuthCertificatesAmendmentJSON: %v", err)
+	}
+
+	gp := &GenesisParameters{}
+	if err := gp.SetAmendableFields([]string{"authcertificates"}); err != nil {
+		t.Fatalf("SetAmendableFields: %v", err)
+	}
+	gp.initialized = true
+
+	if err := gp.Update(amendment); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	want := append(append([]byte{}, der[0]...), der[1]...)
+	if !bytes.Equal(gp.AuthCertificates, want) {
+		t.Fatalf("AuthCertificates = %x, want %x", []byte(gp.AuthCertificates), want)
+	}
+}
+
+func TestAuthCertificatesAmendmentJSONRejectedWhenNotAmendable(t *testing.T) {
+	amendment, err := AuthCertificatesAmendmentJSON([][]byte{{1}})
+	if err != nil {
+		t.Fatalf("AuthCertificatesAmendmentJSON: %v", err)
+	}
+
+	gp := &GenesisParameters{}
+	gp.initialized = true // no AmendableFields set: authcertificates must not be accepted
+
+	if err := gp.Update(amendment); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if len(gp.AuthCertificates) != 0 {
+		t.Fatalf("AuthCertificates was updated despite not being in AmendableFields: %x", []byte(gp.AuthCertificates))
+	}
+}
@@ -0,0 +1,141 @@
+/*
+ * LF: Global Fully Replicated Key/Value Store
+ * Copyright (C) 2018-2019  ZeroTier, Inc.  https://www.zerotier.com/
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ *
+ * --
+ *
+ * You can be released from the requirements of the license by purchasing
+ * a commercial license. Buying such a license is mandatory as soon as you
+ * develop commercial closed-source software that incorporates or links
+ * directly against ZeroTier software without disclosing the source code
+ * of your own application.
+ */
+
+package lf
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// gnsRedirectPrefix marks a value as a delegation to another owner+selector path, resolved by
+// issuing a fresh Query against the delegate. Modeled on GNUnet GNS's PKEY/delegation records.
+var gnsRedirectPrefix = []byte("LF-REDIRECT\x00")
+
+// gnsCNAMEPrefix marks a value as an alias for another selector path under the same owner,
+// modeled on GNUnet GNS's CNAME-like "leho" records.
+var gnsCNAMEPrefix = []byte("LF-CNAME\x00")
+
+// gnsDefaultMaxDepth is used when a recursive Query does not specify MaxDepth.
+const gnsDefaultMaxDepth = 8
+
+// gnsSelector is the JSON shape carried after an LF-REDIRECT or LF-CNAME prefix.
+type gnsSelector struct {
+	Owner     []byte               `json:",omitempty"` // Target owner; empty for LF-CNAME (same owner as the record being resolved)
+	Selectors []QuerySelectorRange `json:",omitempty"`
+}
+
+// parseGNSValue returns the delegation/alias target encoded in value, or ok=false if value is
+// an ordinary (non-redirecting) value.
+func parseGNSValue(value []byte) (sel gnsSelector, sameOwner bool, ok bool) {
+	switch {
+	case bytes.HasPrefix(value, gnsRedirectPrefix):
+		if err := json.Unmarshal(value[len(gnsRedirectPrefix):], &sel); err != nil {
+			return gnsSelector{}, false, false
+		}
+		return sel, false, true
+	case bytes.HasPrefix(value, gnsCNAMEPrefix):
+		if err := json.Unmarshal(value[len(gnsCNAMEPrefix):], &sel); err != nil {
+			return gnsSelector{}, false, false
+		}
+		return sel, true, true
+	}
+	return gnsSelector{}, false, false
+}
+
+// gnsVisitKey returns a stable per-record visited-set key.
+func gnsVisitKey(rd *APIRecordDetail) string {
+	h := rd.Record.Hash()
+	return hex.EncodeToString(h[:])
+}
+
+// resolveRecursive follows LF-REDIRECT/LF-CNAME chains starting from an initial set of query
+// results, using exec to issue each follow-up Query. It is shared by the node's own /query
+// handler (when it supports server-side recursion) and by RemoteNode.ExecuteQuery (for nodes
+// that do not), so the recursion semantics are identical either way.
+//
+// A cycle or unresolved redirect in one initial record's chain is isolated to that record:
+// its QueryResult carries Error instead of a resolved APIRecordDetail/Chain, and resolution
+// continues for the rest of the batch. resolveRecursive itself only returns an error if exec
+// cannot even be attempted (it currently never does; exec errors are per-record, see above).
+func resolveRecursive(initial []APIRecordDetail, originOwner []byte, maxDepth uint, exec func(q *Query) ([]APIRecordDetail, error)) (QueryResults, error) {
+	if maxDepth == 0 {
+		maxDepth = gnsDefaultMaxDepth
+	}
+
+	out := make(QueryResults, 0, len(initial))
+	for i := range initial {
+		chain, final, err := resolveOne(initial[i], originOwner, maxDepth, exec)
+		if err != nil {
+			out = append(out, QueryResult{Error: err.Error()})
+			continue
+		}
+		out = append(out, QueryResult{APIRecordDetail: final, Chain: chain})
+	}
+	return out, nil
+}
+
+func resolveOne(start APIRecordDetail, originOwner []byte, maxDepth uint, exec func(q *Query) ([]APIRecordDetail, error)) ([]APIRecordDetail, APIRecordDetail, error) {
+	visited := make(map[string]bool)
+	chain := make([]APIRecordDetail, 0, 4)
+	cur := start
+	curOwner := originOwner
+
+	for depth := uint(0); depth < maxDepth; depth++ {
+		key := gnsVisitKey(&cur)
+		if visited[key] {
+			return nil, APIRecordDetail{}, fmt.Errorf("cycle detected while resolving %s", base64.RawURLEncoding.EncodeToString(cur.Record.Hash()[:]))
+		}
+		visited[key] = true
+
+		sel, sameOwner, ok := parseGNSValue(cur.Value)
+		if !ok {
+			return chain, cur, nil
+		}
+		chain = append(chain, cur)
+
+		targetOwner := sel.Owner
+		if sameOwner || len(targetOwner) == 0 {
+			targetOwner = curOwner
+		}
+
+		results, err := exec(&Query{Owner: targetOwner, Selectors: sel.Selectors, MaxResults: 1})
+		if err != nil {
+			return nil, APIRecordDetail{}, err
+		}
+		if len(results) == 0 {
+			return nil, APIRecordDetail{}, fmt.Errorf("redirect target did not resolve to any record")
+		}
+
+		cur = results[0]
+		curOwner = targetOwner
+	}
+
+	return nil, APIRecordDetail{}, fmt.Errorf("exceeded maximum resolution depth (%d)", maxDepth)
+}
@@ -0,0 +1,185 @@
+/*
+ * LF: Global Fully Replicated Key/Value Store
+ * Copyright (C) 2018-2019  ZeroTier, Inc.  https://www.zerotier.com/
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ *
+ * --
+ *
+ * You can be released from the requirements of the license by purchasing
+ * a commercial license. Buying such a license is mandatory as soon as you
+ * develop commercial closed-source software that incorporates or links
+ * directly against ZeroTier software without disclosing the source code
+ * of your own application.
+ */
+
+// Package metric is a process-global registry of named counters and gauges, in the style of
+// Tailscale's clientmetric package. Instrumented code paths call NewCounter/NewGauge once
+// (typically in a package-level var) and then Add/Set on the result from a hot path without
+// any further registry lookups.
+package metric
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	registryMu sync.Mutex
+	registry   = map[string]*metric{}
+)
+
+type kind int
+
+const (
+	kindCounter kind = iota
+	kindGauge
+)
+
+type metric struct {
+	name   string
+	help   string
+	k      kind
+	labels map[string]string
+	value  int64 // atomic
+}
+
+// Counter is a monotonically increasing named metric.
+type Counter struct{ m *metric }
+
+// Gauge is an up-or-down named metric.
+type Gauge struct{ m *metric }
+
+func registryKey(name string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return name
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	sb.WriteString(name)
+	for _, k := range keys {
+		sb.WriteByte('\x00')
+		sb.WriteString(k)
+		sb.WriteByte('=')
+		sb.WriteString(labels[k])
+	}
+	return sb.String()
+}
+
+func getOrCreate(name, help string, k kind, labels map[string]string) *metric {
+	key := registryKey(name, labels)
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if m, ok := registry[key]; ok {
+		return m
+	}
+	m := &metric{name: name, help: help, k: k, labels: labels}
+	registry[key] = m
+	return m
+}
+
+// NewCounter returns the named Counter, creating it on first use. Calling NewCounter again
+// with the same name and labels returns the same underlying metric.
+func NewCounter(name, help string, labels map[string]string) *Counter {
+	return &Counter{m: getOrCreate(name, help, kindCounter, labels)}
+}
+
+// Add increments the counter by delta (use 1 for the common case).
+func (c *Counter) Add(delta int64) { atomic.AddInt64(&c.m.value, delta) }
+
+// Value returns the counter's current value.
+func (c *Counter) Value() int64 { return atomic.LoadInt64(&c.m.value) }
+
+// NewGauge returns the named Gauge, creating it on first use.
+func NewGauge(name, help string, labels map[string]string) *Gauge {
+	return &Gauge{m: getOrCreate(name, help, kindGauge, labels)}
+}
+
+// Set assigns the gauge's current value.
+func (g *Gauge) Set(v int64) { atomic.StoreInt64(&g.m.value, v) }
+
+// Add adjusts the gauge by delta, which may be negative.
+func (g *Gauge) Add(delta int64) { atomic.AddInt64(&g.m.value, delta) }
+
+// Value returns the gauge's current value.
+func (g *Gauge) Value() int64 { return atomic.LoadInt64(&g.m.value) }
+
+// Snapshot is one point-in-time reading of a registered metric, used by both exposition formats.
+type Snapshot struct {
+	Name   string
+	Help   string
+	Type   string // "counter" or "gauge"
+	Labels map[string]string
+	Value  int64
+}
+
+// Snapshots returns every currently registered metric's name, help, type, labels, and value,
+// sorted by name then by label set for deterministic output.
+func Snapshots() []Snapshot {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	out := make([]Snapshot, 0, len(registry))
+	for _, m := range registry {
+		typeName := "counter"
+		if m.k == kindGauge {
+			typeName = "gauge"
+		}
+		out = append(out, Snapshot{Name: m.name, Help: m.help, Type: typeName, Labels: m.labels, Value: atomic.LoadInt64(&m.value)})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Name != out[j].Name {
+			return out[i].Name < out[j].Name
+		}
+		return registryKey(out[i].Name, out[i].Labels) < registryKey(out[j].Name, out[j].Labels)
+	})
+	return out
+}
+
+// WritePrometheus writes every snapshot in Prometheus text exposition format.
+func WritePrometheus(w *strings.Builder) {
+	seen := make(map[string]bool)
+	for _, s := range Snapshots() {
+		if !seen[s.Name] {
+			seen[s.Name] = true
+			if len(s.Help) > 0 {
+				fmt.Fprintf(w, "# HELP %s %s\n", s.Name, s.Help)
+			}
+			fmt.Fprintf(w, "# TYPE %s %s\n", s.Name, s.Type)
+		}
+		if len(s.Labels) == 0 {
+			fmt.Fprintf(w, "%s %d\n", s.Name, s.Value)
+			continue
+		}
+		keys := make([]string, 0, len(s.Labels))
+		for k := range s.Labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var lb strings.Builder
+		for i, k := range keys {
+			if i > 0 {
+				lb.WriteByte(',')
+			}
+			fmt.Fprintf(&lb, "%s=%q", k, s.Labels[k])
+		}
+		fmt.Fprintf(w, "%s{%s} %d\n", s.Name, lb.String(), s.Value)
+	}
+}
@@ -0,0 +1,127 @@
+/*
+ * LF: Global Fully Replicated Key/Value Store
+ * Copyright (C) 2018-2019  ZeroTier, Inc.  https://www.zerotier.com/
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ *
+ * --
+ *
+ * You can be released from the requirements of the license by purchasing
+ * a commercial license. Buying such a license is mandatory as soon as you
+ * develop commercial closed-source software that incorporates or links
+ * directly against ZeroTier software without disclosing the source code
+ * of your own application.
+ */
+
+package metric
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+// uniqueLabels returns a label set guaranteed not to collide with any other test's metrics,
+// since the registry is process-global and shared across all tests in this package.
+func uniqueLabels(t *testing.T, extra string) map[string]string {
+	t.Helper()
+	return map[string]string{"test": t.Name() + extra}
+}
+
+func TestCounterMonotonicity(t *testing.T) {
+	c := NewCounter("lf_test_counter_total", "A test counter.", uniqueLabels(t, ""))
+	if c.Value() != 0 {
+		t.Fatalf("new counter Value() = %d, want 0", c.Value())
+	}
+
+	c.Add(1)
+	c.Add(5)
+	if c.Value() != 6 {
+		t.Fatalf("Value() after adds = %d, want 6", c.Value())
+	}
+
+	var wg sync.WaitGroup
+	const goroutines, perGoroutine = 10, 1000
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perGoroutine; j++ {
+				c.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	want := int64(6 + goroutines*perGoroutine)
+	if got := c.Value(); got != want {
+		t.Fatalf("Value() after concurrent adds = %d, want %d (counter must never go backwards or lose increments)", got, want)
+	}
+}
+
+func TestNewCounterReturnsSameUnderlyingMetric(t *testing.T) {
+	labels := uniqueLabels(t, "")
+	a := NewCounter("lf_test_counter_dedup_total", "A test counter.", labels)
+	a.Add(3)
+	b := NewCounter("lf_test_counter_dedup_total", "A test counter.", labels)
+	if b.Value() != 3 {
+		t.Fatalf("second NewCounter with identical name/labels returned a distinct metric: Value() = %d, want 3", b.Value())
+	}
+}
+
+func TestGaugeUpAndDown(t *testing.T) {
+	g := NewGauge("lf_test_gauge", "A test gauge.", uniqueLabels(t, ""))
+	g.Set(10)
+	if g.Value() != 10 {
+		t.Fatalf("Value() after Set(10) = %d, want 10", g.Value())
+	}
+	g.Add(-3)
+	if g.Value() != 7 {
+		t.Fatalf("Value() after Add(-3) = %d, want 7 (gauges, unlike counters, may decrease)", g.Value())
+	}
+}
+
+// TestLabelCardinalityBounded verifies that repeatedly requesting a metric under the same
+// label set never grows the registry, so a label driven by unbounded input (e.g. a raw
+// client-supplied string) cannot be used to leak memory via cardinality explosion -- callers
+// are still responsible for choosing bounded label values, but the registry itself must not
+// amplify a bounded number of distinct calls into an unbounded number of registry entries.
+func TestLabelCardinalityBounded(t *testing.T) {
+	labels := uniqueLabels(t, "")
+	before := len(Snapshots())
+	for i := 0; i < 1000; i++ {
+		NewCounter("lf_test_cardinality_total", "A test counter.", labels).Add(1)
+	}
+	after := len(Snapshots())
+	if after != before+1 {
+		t.Fatalf("registry grew by %d entries for 1000 calls with identical labels, want exactly 1 new entry", after-before)
+	}
+}
+
+func TestWritePrometheusFormat(t *testing.T) {
+	NewCounter("lf_test_prom_total", "Help text.", uniqueLabels(t, "")).Add(42)
+
+	var sb strings.Builder
+	WritePrometheus(&sb)
+	out := sb.String()
+
+	if !strings.Contains(out, "# HELP lf_test_prom_total Help text.\n") {
+		t.Fatalf("output missing HELP line:\n%s", out)
+	}
+	if !strings.Contains(out, "# TYPE lf_test_prom_total counter\n") {
+		t.Fatalf("output missing TYPE line:\n%s", out)
+	}
+	if !strings.Contains(out, "lf_test_prom_total{test=") || !strings.Contains(out, "} 42\n") {
+		t.Fatalf("output missing labeled sample line:\n%s", out)
+	}
+}
@@ -0,0 +1,101 @@
+/*
+ * LF: Global Fully Replicated Key/Value Store
+ * Copyright (C) 2018-2019  ZeroTier, Inc.  https://www.zerotier.com/
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ *
+ * --
+ *
+ * You can be released from the requirements of the license by purchasing
+ * a commercial license. Buying such a license is mandatory as soon as you
+ * develop commercial closed-source software that incorporates or links
+ * directly against ZeroTier software without disclosing the source code
+ * of your own application.
+ */
+
+package lf
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/pixelkaiser/lf/pkg/lf/metric"
+)
+
+// metricRequestsTotal counts every HTTP API request, labeled by endpoint and result
+// ("ok" or "error"). Dispatch-site instrumentation in apiCreateHTTPServeMux covers /p, /g, /r,
+// /query, and /connect.
+func metricRequestsTotal(endpoint, result string) {
+	metric.NewCounter("lf_requests_total", "Total HTTP API requests.", map[string]string{"endpoint": endpoint, "result": result}).Add(1)
+}
+
+// metricAuthFailuresTotal counts rejected requests, labeled by the endpoint that rejected them.
+func metricAuthFailuresTotal(endpoint string) {
+	metric.NewCounter("lf_auth_failures_total", "Total requests rejected by an authorization check.", map[string]string{"endpoint": endpoint}).Add(1)
+}
+
+// metricBodyFormatTotal counts requests by whether they used msgpack or JSON encoding.
+func metricBodyFormatTotal(format string) {
+	metric.NewCounter("lf_request_body_format_total", "Total requests by body encoding.", map[string]string{"format": format}).Add(1)
+}
+
+// metricRecordsImportedTotal counts records successfully committed via /p, the lf.put RPC
+// method, and /post/stream.
+var metricRecordsImportedTotal = metric.NewCounter("lf_records_imported_total", "Total records imported via /p, lf.put, and /post/stream.", nil)
+
+// metricPeersConnectedGauge tracks the current number of connected peers.
+var metricPeersConnectedGauge = metric.NewGauge("lf_peers_connected", "Number of currently connected peers.", nil)
+
+// observeRequestBodyFormat records whether req's Content-Type indicates msgpack or JSON, per
+// the same sniffing apiReadJSON already performs.
+func observeRequestBodyFormat(req *http.Request) {
+	ct, haveCT := req.Header["Content-Type"]
+	if haveCT {
+		for i := range ct {
+			if strings.Contains(ct[i], "msgpack") {
+				metricBodyFormatTotal("msgpack")
+				return
+			}
+		}
+	}
+	metricBodyFormatTotal("json")
+}
+
+// apiAddMetricsHandlers registers /metrics (Prometheus text exposition) and /metrics.json.
+func apiAddMetricsHandlers(smux *http.ServeMux, n *Node) {
+	smux.HandleFunc("/metrics", func(out http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			apiSendJSON(out, req, http.StatusMethodNotAllowed, &APIError{Code: http.StatusMethodNotAllowed, Message: req.Method + " not supported for this path"})
+			return
+		}
+		metricPeersConnectedGauge.Set(int64(len(apiMakePeerArray(n))))
+
+		var sb strings.Builder
+		metric.WritePrometheus(&sb)
+		out.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		out.WriteHeader(http.StatusOK)
+		if req.Method != http.MethodHead {
+			_, _ = out.Write([]byte(sb.String()))
+		}
+	})
+
+	smux.HandleFunc("/metrics.json", func(out http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			apiSendJSON(out, req, http.StatusMethodNotAllowed, &APIError{Code: http.StatusMethodNotAllowed, Message: req.Method + " not supported for this path"})
+			return
+		}
+		metricPeersConnectedGauge.Set(int64(len(apiMakePeerArray(n))))
+		apiSendJSON(out, req, http.StatusOK, metric.Snapshots())
+	})
+}
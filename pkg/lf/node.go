@@ -0,0 +1,107 @@
+/*
+ * LF: Global Fully Replicated Key/Value Store
+ * Copyright (C) 2018-2019  ZeroTier, Inc.  https://www.zerotier.com/
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ *
+ * --
+ *
+ * You can be released from the requirements of the license by purchasing
+ * a commercial license. Buying such a license is mandatory as soon as you
+ * develop commercial closed-source software that incorporates or links
+ * directly against ZeroTier software without disclosing the source code
+ * of your own application.
+ */
+
+package lf
+
+import (
+	"net"
+	"sync"
+)
+
+// host is one connected peer link as seen by the HTTP API's /peers and /status reporting.
+// The node's wire protocol and connection management live outside this package snapshot;
+// this carries only the fields and methods the API surface reads.
+type host struct {
+	RemoteAddress      *net.TCPAddr
+	TotalBytesSent     uint64
+	TotalBytesReceived uint64
+	Latency            int
+
+	connected bool
+}
+
+// Connected reports whether this peer link is currently established.
+func (h *host) Connected() bool { return h.connected }
+
+// Node is a running LF node: its record store, its connected peers, and the capability,
+// OIDC, and subscription machinery that gate and fan out access to them over the HTTP API.
+type Node struct {
+	db        *db
+	startTime uint64
+
+	hostsLock sync.RWMutex
+	hosts     []*host
+
+	capAuth      *CapabilityAuthority
+	oidcVerifier *OIDCVerifier
+	subscribers  *subscriberRegistry
+	logRing      *logRingBuffer
+}
+
+// NewNode constructs a Node around a fresh record store. capAuthKey, if non-empty, seeds a
+// CapabilityAuthority so apiAuthorize can do fine-grained token checks; an empty key leaves
+// capAuth nil, and apiAuthorize falls back to the legacy trusted-host check. enableLogRing
+// turns on the in-memory diagnostic log ring that /bugreport reads.
+func NewNode(capAuthKey []byte, enableLogRing bool) *Node {
+	n := &Node{
+		db:           newDB(),
+		startTime:    TimeMs(),
+		subscribers:  newSubscriberRegistry(),
+		oidcVerifier: NewOIDCVerifier(),
+	}
+	if len(capAuthKey) > 0 {
+		n.capAuth = NewCapabilityAuthority(capAuthKey)
+	}
+	if enableLogRing {
+		n.logRing = newLogRingBuffer()
+	}
+	return n
+}
+
+// addRecord commits rec to this node's store, then publishes it to any live /subscribe
+// registrations. It performs no validation of its own; capability/OIDC authorization checks
+// happen above this call in the API handlers. addRecord is the single chokepoint every record
+// passes through to reach the store, whether submitted locally (/p, lf.put, /post/stream) or
+// received from a peer during gossip sync, so publishing here (rather than at each caller)
+// is what makes /subscribe a general feed over everything the node commits, not just local
+// submissions.
+func (n *Node) addRecord(rec *Record) error {
+	if err := n.db.addRecord(rec); err != nil {
+		return err
+	}
+	n.subscribers.Publish(&APIRecordDetail{Record: *rec})
+	return nil
+}
+
+// connectHost records a new outbound peer link to ip:port. Establishing the actual LF
+// wire-protocol connection is the job of the node's networking layer, outside this package
+// snapshot; this keeps the bookkeeping /peers and /status depend on in sync with that layer.
+func (n *Node) connectHost(ip net.IP, port int) error {
+	n.hostsLock.Lock()
+	defer n.hostsLock.Unlock()
+	n.hosts = append(n.hosts, &host{RemoteAddress: &net.TCPAddr{IP: ip, Port: port}})
+	return nil
+}
@@ -0,0 +1,228 @@
+/*
+ * LF: Global Fully Replicated Key/Value Store
+ * Copyright (C) 2018-2019  ZeroTier, Inc.  https://www.zerotier.com/
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ *
+ * --
+ *
+ * You can be released from the requirements of the license by purchasing
+ * a commercial license. Buying such a license is mandatory as soon as you
+ * develop commercial closed-source software that incorporates or links
+ * directly against ZeroTier software without disclosing the source code
+ * of your own application.
+ */
+
+package lf
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jwt"
+)
+
+// OIDCIssuer describes a trusted OpenID Connect issuer whose ID tokens may authorize records
+// in place of (or alongside) an X.509 signature from AuthCertificates.
+type OIDCIssuer struct {
+	Issuer           string   `json:",omitempty"` // Issuer URL, e.g. https://accounts.google.com
+	Audiences        []string `json:",omitempty"` // Allowed "aud" values
+	FingerprintClaim string   `json:",omitempty"` // Claim whose value must equal the owner's base62 fingerprint
+}
+
+// OIDCAuthEnvelope is carried instead of (or alongside) a CA signature when a record is
+// authorized via an OIDC ID token rather than AuthCertificates.
+type OIDCAuthEnvelope struct {
+	IDToken []byte `msgpack:"JWT,omitempty" json:",omitempty"` // Signed JWT (compact serialization)
+}
+
+// jwksEntry caches one issuer's JWKS along with when it was fetched.
+type jwksEntry struct {
+	set     jwk.Set
+	fetched time.Time
+	jwksURI string
+}
+
+// OIDCVerifier fetches, caches, and rotates each configured issuer's JWKS and verifies ID
+// tokens against it. A single instance is meant to be shared by a node for the lifetime of
+// its current GenesisParameters.
+type OIDCVerifier struct {
+	// MaxAge bounds how long a cached JWKS is trusted before being re-fetched (default 1 hour).
+	MaxAge time.Duration
+	// HTTPClient is used for discovery and JWKS fetches; defaults to http.DefaultClient.
+	HTTPClient *http.Client
+
+	mu    sync.Mutex
+	cache map[string]*jwksEntry // keyed by issuer URL
+}
+
+// NewOIDCVerifier creates an OIDCVerifier with sane defaults.
+func NewOIDCVerifier() *OIDCVerifier {
+	return &OIDCVerifier{MaxAge: time.Hour, HTTPClient: http.DefaultClient, cache: make(map[string]*jwksEntry)}
+}
+
+// discoverJWKSURI fetches the issuer's /.well-known/openid-configuration document and
+// extracts jwks_uri.
+func (v *OIDCVerifier) discoverJWKSURI(issuer string) (string, error) {
+	resp, err := v.HTTPClient.Get(strings.TrimRight(issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("oidc discovery for %s returned HTTP %d", issuer, resp.StatusCode)
+	}
+	var doc struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return "", err
+	}
+	if len(doc.JWKSURI) == 0 {
+		return "", fmt.Errorf("oidc discovery for %s did not include jwks_uri", issuer)
+	}
+	return doc.JWKSURI, nil
+}
+
+// keySetFor returns a (possibly cached) JWKS for issuer, refreshing it if it is stale or
+// missing. This is also where key rotation is picked up: a new fetch simply replaces the
+// cached set, so a newly rotated-in key becomes trusted on the next verification after MaxAge
+// elapses, while keys the issuer has not yet removed continue to validate older tokens.
+func (v *OIDCVerifier) keySetFor(issuer string) (jwk.Set, error) {
+	v.mu.Lock()
+	entry := v.cache[issuer]
+	v.mu.Unlock()
+
+	if entry != nil && time.Since(entry.fetched) < v.MaxAge {
+		return entry.set, nil
+	}
+
+	jwksURI := ""
+	if entry != nil {
+		jwksURI = entry.jwksURI
+	} else {
+		uri, err := v.discoverJWKSURI(issuer)
+		if err != nil {
+			return nil, err
+		}
+		jwksURI = uri
+	}
+
+	set, err := jwk.Fetch(context.Background(), jwksURI, jwk.WithHTTPClient(v.HTTPClient))
+	if err != nil {
+		if entry != nil {
+			// Fall back to the last known-good set rather than hard-failing on a transient
+			// fetch error; rotation will simply be picked up on a later call.
+			return entry.set, nil
+		}
+		return nil, err
+	}
+
+	v.mu.Lock()
+	v.cache[issuer] = &jwksEntry{set: set, fetched: time.Now(), jwksURI: jwksURI}
+	v.mu.Unlock()
+	return set, nil
+}
+
+// VerifyFingerprint verifies env's ID token against one of the configured issuers and, on
+// success, returns the base62 owner fingerprint claim so the caller can compare it against
+// the record's actual owner. It returns an error if the token is invalid, expired, or its
+// issuer/audience is not among issuers.
+func (v *OIDCVerifier) VerifyFingerprint(env *OIDCAuthEnvelope, issuers []OIDCIssuer) (string, error) {
+	if env == nil || len(env.IDToken) == 0 {
+		return "", fmt.Errorf("missing OIDC ID token")
+	}
+
+	unverified, err := jwt.Parse(env.IDToken)
+	if err != nil {
+		return "", err
+	}
+	iss := unverified.Issuer()
+
+	var matched *OIDCIssuer
+	for i := range issuers {
+		if issuers[i].Issuer == iss {
+			matched = &issuers[i]
+			break
+		}
+	}
+	if matched == nil {
+		return "", fmt.Errorf("issuer %s is not trusted by this network's OIDCIssuers", iss)
+	}
+
+	keySet, err := v.keySetFor(iss)
+	if err != nil {
+		return "", err
+	}
+
+	tok, err := jwt.Parse(env.IDToken, jwt.WithKeySet(keySet), jwt.WithValidate(true))
+	if err != nil {
+		return "", err
+	}
+
+	audOK := false
+	for _, want := range matched.Audiences {
+		for _, got := range tok.Audience() {
+			if want == got {
+				audOK = true
+			}
+		}
+	}
+	if !audOK {
+		return "", fmt.Errorf("token audience does not match any allowed audience for issuer %s", iss)
+	}
+
+	claims, err := tok.AsMap(nil)
+	if err != nil {
+		return "", err
+	}
+	fp, _ := claims[matched.FingerprintClaim].(string)
+	if len(fp) == 0 {
+		return "", fmt.Errorf("token is missing fingerprint claim %s", matched.FingerprintClaim)
+	}
+	return fp, nil
+}
+
+// ownerFingerprintBase62 renders an owner's public key fingerprint the same way a
+// FingerprintClaim value is expected to be encoded, so callers can compare it against the
+// value returned by VerifyFingerprint.
+func ownerFingerprintBase62(ownerPublic []byte) string {
+	return Base62Encode(ownerPublic)
+}
+
+// apiAuthorizeRecordOIDC verifies env against n's currently configured OIDCIssuers and reports
+// whether it authorizes rec: the token must verify against one of the trusted issuers and its
+// fingerprint claim must match rec's owner. A record authorized this way is treated exactly
+// like one bearing a CA signature from AuthCertificates -- it bypasses the normal cap:put
+// grant requirement in the /p and lf.put submission paths.
+func apiAuthorizeRecordOIDC(n *Node, rec *Record, env *OIDCAuthEnvelope) bool {
+	if env == nil || n.oidcVerifier == nil || rec == nil {
+		return false
+	}
+	gp, err := n.db.genesisParameters()
+	if err != nil || len(gp.OIDCIssuers) == 0 {
+		return false
+	}
+	fp, err := n.oidcVerifier.VerifyFingerprint(env, gp.OIDCIssuers)
+	if err != nil {
+		return false
+	}
+	return fp == ownerFingerprintBase62(rec.Owner)
+}
@@ -0,0 +1,153 @@
+/*
+ * LF: Global Fully Replicated Key/Value Store
+ * Copyright (C) 2018-2019  ZeroTier, Inc.  https://www.zerotier.com/
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ *
+ * --
+ *
+ * You can be released from the requirements of the license by purchasing
+ * a commercial license. Buying such a license is mandatory as soon as you
+ * develop commercial closed-source software that incorporates or links
+ * directly against ZeroTier software without disclosing the source code
+ * of your own application.
+ */
+
+package lf
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jws"
+	"github.com/lestrrat-go/jwx/jwt"
+)
+
+// fakeIssuer is a minimal in-process OIDC issuer: it serves discovery and a JWKS document and
+// signs tokens with its own RSA key, so OIDCVerifier can be exercised without a real IdP.
+type fakeIssuer struct {
+	srv     *httptest.Server
+	priv    *rsa.PrivateKey
+	keyID   string
+	issuer  string
+	jwksURI string
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	t.Helper()
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey: %v", err)
+	}
+	fi := &fakeIssuer{priv: priv, keyID: "test-key-1"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{"jwks_uri": fi.jwksURI})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		pub, err := jwk.New(&priv.PublicKey)
+		if err != nil {
+			t.Fatalf("jwk.New: %v", err)
+		}
+		_ = pub.Set(jwk.KeyIDKey, fi.keyID)
+		_ = pub.Set(jwk.AlgorithmKey, jwa.RS256.String())
+		set := jwk.NewSet()
+		set.Add(pub)
+		_ = json.NewEncoder(w).Encode(set)
+	})
+
+	fi.srv = httptest.NewServer(mux)
+	fi.issuer = fi.srv.URL
+	fi.jwksURI = fi.srv.URL + "/jwks"
+	return fi
+}
+
+func (fi *fakeIssuer) close() { fi.srv.Close() }
+
+// token builds and signs an ID token, letting the caller override claims for negative tests.
+func (fi *fakeIssuer) token(t *testing.T, audience string, fingerprintClaim, fingerprint string, expiresIn time.Duration) []byte {
+	t.Helper()
+	builder := jwt.NewBuilder().
+		Issuer(fi.issuer).
+		Audience([]string{audience}).
+		IssuedAt(time.Now()).
+		Expiration(time.Now().Add(expiresIn)).
+		Claim(fingerprintClaim, fingerprint)
+	tok, err := builder.Build()
+	if err != nil {
+		t.Fatalf("builder.Build: %v", err)
+	}
+	hdrs := jws.NewHeaders()
+	_ = hdrs.Set(jws.KeyIDKey, fi.keyID)
+	signed, err := jwt.Sign(tok, jwa.RS256, fi.priv, jwt.WithHeaders(hdrs))
+	if err != nil {
+		t.Fatalf("jwt.Sign: %v", err)
+	}
+	return signed
+}
+
+func TestOIDCVerifierVerifyFingerprint(t *testing.T) {
+	fi := newFakeIssuer(t)
+	defer fi.close()
+
+	issuers := []OIDCIssuer{{Issuer: fi.issuer, Audiences: []string{"lf-network"}, FingerprintClaim: "lf_owner_fp"}}
+	v := NewOIDCVerifier()
+
+	t.Run("valid token", func(t *testing.T) {
+		env := &OIDCAuthEnvelope{IDToken: fi.token(t, "lf-network", "lf_owner_fp", "abc123", time.Hour)}
+		fp, err := v.VerifyFingerprint(env, issuers)
+		if err != nil {
+			t.Fatalf("VerifyFingerprint: %v", err)
+		}
+		if fp != "abc123" {
+			t.Fatalf("got fingerprint %q, want abc123", fp)
+		}
+	})
+
+	t.Run("expired token", func(t *testing.T) {
+		env := &OIDCAuthEnvelope{IDToken: fi.token(t, "lf-network", "lf_owner_fp", "abc123", -time.Hour)}
+		if _, err := v.VerifyFingerprint(env, issuers); err == nil {
+			t.Fatal("expected an error for an expired token")
+		}
+	})
+
+	t.Run("wrong audience", func(t *testing.T) {
+		env := &OIDCAuthEnvelope{IDToken: fi.token(t, "some-other-audience", "lf_owner_fp", "abc123", time.Hour)}
+		if _, err := v.VerifyFingerprint(env, issuers); err == nil {
+			t.Fatal("expected an error for a token with the wrong audience")
+		}
+	})
+
+	t.Run("untrusted issuer", func(t *testing.T) {
+		env := &OIDCAuthEnvelope{IDToken: fi.token(t, "lf-network", "lf_owner_fp", "abc123", time.Hour)}
+		if _, err := v.VerifyFingerprint(env, nil); err == nil {
+			t.Fatal("expected an error when no issuer is configured")
+		}
+	})
+
+	t.Run("missing fingerprint claim", func(t *testing.T) {
+		env := &OIDCAuthEnvelope{IDToken: fi.token(t, "lf-network", "some_other_claim", "abc123", time.Hour)}
+		if _, err := v.VerifyFingerprint(env, issuers); err == nil {
+			t.Fatal("expected an error when the fingerprint claim is absent")
+		}
+	})
+}
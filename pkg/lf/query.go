@@ -0,0 +1,75 @@
+/*
+ * LF: Global Fully Replicated Key/Value Store
+ * Copyright (C) 2018-2019  ZeroTier, Inc.  https://www.zerotier.com/
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ *
+ * --
+ *
+ * You can be released from the requirements of the license by purchasing
+ * a commercial license. Buying such a license is mandatory as soon as you
+ * develop commercial closed-source software that incorporates or links
+ * directly against ZeroTier software without disclosing the source code
+ * of your own application.
+ */
+
+package lf
+
+// QuerySelectorRange narrows a query to selector ordinals falling within [Low, High].
+type QuerySelectorRange struct {
+	Low  []byte `json:",omitempty"` // Low selector ordinal key (inclusive)
+	High []byte `json:",omitempty"` // High selector ordinal key (inclusive)
+}
+
+// Query (/query) is a richer alternative to APIGet that supports selector ranges, letting
+// callers ask for e.g. "every record under this owner whose first selector falls between X
+// and Y" rather than an exact match.
+type Query struct {
+	Owner      []byte               `json:",omitempty"` // Owner (32 bytes), or empty to match any owner
+	Selectors  []QuerySelectorRange `json:",omitempty"` // Selector ordinal ranges, ANDed together
+	MaxResults uint                 `json:",omitempty"` // Maximum total results or 0 for APIMaxResults
+
+	// Recursive, if true, causes the node (or RemoteNode.ExecuteQuery, for nodes that do not
+	// yet implement server-side recursion) to follow LF-REDIRECT and LF-CNAME values found in
+	// results, chasing the chain until it bottoms out in an ordinary value, a cycle is
+	// detected, or MaxDepth is reached.
+	Recursive bool `json:",omitempty"`
+
+	// MaxDepth caps how many hops a recursive resolution will follow. Zero means the default
+	// of 8.
+	MaxDepth uint `json:",omitempty"`
+}
+
+// QueryResult is a single matched record, optionally followed by the chain of redirect/cname
+// hops that were resolved to reach it when the originating Query had Recursive set.
+type QueryResult struct {
+	APIRecordDetail
+	Chain []APIRecordDetail `msgpack:"CH,omitempty" json:",omitempty"` // Intermediate records followed to reach this result, in order, for audit
+	Error string            `msgpack:"E,omitempty" json:",omitempty"`  // Set instead of APIRecordDetail/Chain if this one result's chain failed to resolve (e.g. a cycle or dead redirect); does not affect other results
+}
+
+// QueryResults is the response to a Query, one entry per matching record (after following any
+// recursive chains).
+type QueryResults []QueryResult
+
+// queryResultsFromDetails wraps plain match results (no recursion performed) as QueryResults
+// with empty Chain fields, the shape a non-recursive /query response shares with a recursive
+// one so clients can treat both uniformly.
+func queryResultsFromDetails(details []APIRecordDetail) QueryResults {
+	out := make(QueryResults, 0, len(details))
+	for i := range details {
+		out = append(out, QueryResult{APIRecordDetail: details[i]})
+	}
+	return out
+}
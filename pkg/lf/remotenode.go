@@ -38,6 +38,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/pixelkaiser/lf/pkg/lf/secrets"
 )
 
 // APIMaxResponseSize is a sanity limit on the maximum size of a response from the LF HTTP API (can be increased)
@@ -115,7 +117,10 @@ func NewRemoteNode(urlStr string) (RemoteNode, error) {
 	return RemoteNode(upstr), nil
 }
 
-// AddRecord submits this record for addition to the data store.
+// AddRecord submits an already-built and signed record for addition to the data store. It
+// never sees or handles a private key itself; callers that need to sign a new record from a
+// named key should build it via AddRecordFromSecretsManager instead of loading an Owner's
+// private key themselves and calling NewRecord directly.
 func (rn RemoteNode) AddRecord(rec *Record) error {
 	resp, err := httpClient.Post(string(rn)+"/post", "application/octet-stream", bytes.NewReader(rec.Bytes()))
 	if err != nil {
@@ -145,6 +150,26 @@ func (rn RemoteNode) AddRecord(rec *Record) error {
 	return nil
 }
 
+// AddRecordFromSecretsManager builds, signs, and submits a new record using an owner private
+// key loaded from sm by name rather than one passed in directly by the caller. This is the
+// preferred way to add records on behalf of a persistent identity (as opposed to AddRecord,
+// which expects the caller to have already built and signed the record itself).
+func (rn RemoteNode) AddRecordFromSecretsManager(recordType byte, value []byte, links [][32]byte, selectors [2][]byte, plainTextValue bool, ts uint64, wg *Wharrgarblr, sm secrets.Manager, ownerSecretName string) (*Record, error) {
+	owner, err := LoadOwnerFromSecretsManager(sm, ownerSecretName)
+	if err != nil {
+		return nil, err
+	}
+	var plainTextKey []byte
+	if plainTextValue {
+		plainTextKey = selectors[0]
+	}
+	rec, err := NewRecord(recordType, value, links, nil, selectors[:], plainTextKey, nil, ts, wg, owner)
+	if err != nil {
+		return nil, err
+	}
+	return rec, rn.AddRecord(rec)
+}
+
 // GetRecord looks up a record by its exact hash.
 func (rn RemoteNode) GetRecord(hash []byte) (*Record, error) {
 	if len(hash) == 32 {
@@ -229,7 +254,11 @@ func (rn RemoteNode) Links(count int) ([][32]byte, int64, error) {
 	return nil, -1, ErrAPI{Code: resp.StatusCode}
 }
 
-// ExecuteQuery executes a query against this remote node.
+// ExecuteQuery executes a query against this remote node. If q.Recursive is set and the
+// node's response shows no server-side resolution (the first result carries no Chain), this
+// performs GNS-style recursion (following LF-REDIRECT/LF-CNAME values) client-side, so callers
+// get the same behavior regardless of whether the remote node supports server-side recursion.
+// A response whose first result already has a Chain is returned as the node sent it.
 func (rn RemoteNode) ExecuteQuery(q *Query) (QueryResults, error) {
 	body, err := apiRequest(string(rn)+"/query", q)
 	if err != nil {
@@ -240,7 +269,27 @@ func (rn RemoteNode) ExecuteQuery(q *Query) (QueryResults, error) {
 	if err != nil {
 		return nil, err
 	}
-	return qr, nil
+	if !q.Recursive || len(qr) == 0 || len(qr[0].Chain) > 0 {
+		// Either recursion wasn't requested, or the node already resolved it server-side.
+		return qr, nil
+	}
+
+	initial := make([]APIRecordDetail, 0, len(qr))
+	for i := range qr {
+		initial = append(initial, qr[i].APIRecordDetail)
+	}
+
+	return resolveRecursive(initial, q.Owner, q.MaxDepth, func(sub *Query) ([]APIRecordDetail, error) {
+		subResults, err := rn.ExecuteQuery(sub)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]APIRecordDetail, 0, len(subResults))
+		for i := range subResults {
+			out = append(out, subResults[i].APIRecordDetail)
+		}
+		return out, nil
+	})
 }
 
 // Connect instructs this node to initiate a remote connection
@@ -254,4 +303,4 @@ func (rn RemoteNode) Connect(ip net.IP, port int, identity []byte) error {
 }
 
 // IsLocal always returns false for RemoteNode.
-func (rn RemoteNode) IsLocal() bool { return false }
\ No newline at end of file
+func (rn RemoteNode) IsLocal() bool { return false }
@@ -0,0 +1,310 @@
+/*
+ * LF: Global Fully Replicated Key/Value Store
+ * Copyright (C) 2018-2019  ZeroTier, Inc.  https://www.zerotier.com/
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ *
+ * --
+ *
+ * You can be released from the requirements of the license by purchasing
+ * a commercial license. Buying such a license is mandatory as soon as you
+ * develop commercial closed-source software that incorporates or links
+ * directly against ZeroTier software without disclosing the source code
+ * of your own application.
+ */
+
+package lf
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// StreamCursor identifies a position in a query's result ordering so StreamQuery can resume
+// after a disconnect without re-delivering records the caller already has.
+type StreamCursor struct {
+	Owner []byte `json:",omitempty"`
+	TS    uint64 `json:",omitempty"`
+	Hash  []byte `json:",omitempty"`
+}
+
+// AddResult is sent back over the ack channel of AddRecords, one per submitted record.
+type AddResult struct {
+	Hash  [32]byte `msgpack:"H"`
+	Error string   `msgpack:"E,omitempty" json:",omitempty"` // Empty on success
+}
+
+// streamAddRequestBacklog bounds how many decoded QueryResult values StreamQuery buffers for
+// the caller before its reader goroutine blocks. AddRecords does not use it: it reads records
+// straight from the caller's channel with no buffer of its own.
+const streamAddRequestBacklog = 64
+
+// streamAckBacklog bounds how many unread acks AddRecords will buffer before the reader
+// goroutine blocks.
+const streamAckBacklog = 64
+
+// AddRecords multiplexes many records over a single long-lived POST to /post/stream using
+// length-prefixed framing (a uint32 big-endian length followed by that many bytes of raw
+// record data), with a gzip-compressed request body. The returned channel carries one
+// AddResult per submitted record, in submission order, so failed records can be retried
+// individually without resubmitting the whole batch.
+func (rn RemoteNode) AddRecords(records <-chan *Record) (<-chan AddResult, error) {
+	pr, pw := io.Pipe()
+	gzw := gzip.NewWriter(pw)
+
+	req, err := http.NewRequest(http.MethodPost, string(rn)+"/post/stream", pr)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-lf-record-stream")
+	req.Header.Set("Content-Encoding", "gzip")
+
+	results := make(chan AddResult, streamAckBacklog)
+
+	go func() {
+		defer pw.Close()
+		defer gzw.Close()
+		for rec := range records {
+			data := rec.Bytes()
+			var lenBuf [4]byte
+			binary.BigEndian.PutUint32(lenBuf[:], uint32(len(data)))
+			if _, err := gzw.Write(lenBuf[:]); err != nil {
+				return
+			}
+			if _, err := gzw.Write(data); err != nil {
+				return
+			}
+			// Flush so the server sees each record promptly rather than waiting for gzip's
+			// internal buffer to fill, which would stall acks on a slow/sparse producer.
+			if err := gzw.Flush(); err != nil {
+				return
+			}
+		}
+	}()
+
+	go func() {
+		defer close(results)
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return
+		}
+
+		body := resp.Body
+		dec := json.NewDecoder(bufio.NewReader(body))
+		for {
+			var ack AddResult
+			if err := dec.Decode(&ack); err != nil {
+				return
+			}
+			results <- ack
+		}
+	}()
+
+	return results, nil
+}
+
+// StreamQuery hits /query/stream and emits QueryResult values as NDJSON frames arrive, rather
+// than waiting for the whole result set to buffer as ExecuteQuery does. If since is non-nil it
+// is sent alongside q, and the node skips results up to and including the record whose hash
+// is since.Hash.
+func (rn RemoteNode) StreamQuery(q *Query, since *StreamCursor) (<-chan QueryResult, error) {
+	body := struct {
+		*Query
+		Since *StreamCursor `json:",omitempty"`
+	}{Query: q, Since: since}
+
+	payload, err := json.Marshal(&body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, string(rn)+"/query/stream", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("query stream request failed with HTTP %d", resp.StatusCode)
+	}
+
+	out := make(chan QueryResult, streamAddRequestBacklog)
+	go func() {
+		defer close(out)
+		defer resp.Body.Close()
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 0, 64*1024), APIMaxResponseSize)
+		for scanner.Scan() {
+			var qr QueryResult
+			if err := json.Unmarshal(scanner.Bytes(), &qr); err != nil {
+				return
+			}
+			out <- qr
+		}
+	}()
+
+	return out, nil
+}
+
+// apiAddStreamHandlers registers /post/stream and /query/stream on smux, the server-side
+// counterparts of AddRecords and StreamQuery above.
+func apiAddStreamHandlers(smux *http.ServeMux, n *Node) {
+	smux.HandleFunc("/post/stream", func(out http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost && req.Method != http.MethodPut {
+			apiSendJSON(out, req, http.StatusMethodNotAllowed, &APIError{Code: http.StatusMethodNotAllowed, Message: req.Method + " not supported for this path"})
+			return
+		}
+		if !apiAuthorize(n, req, CapPut) {
+			metricAuthFailuresTotal("/post/stream")
+			apiSendJSON(out, req, http.StatusForbidden, &APIError{Code: http.StatusForbidden, Message: "submitting records requires the cap:put capability"})
+			return
+		}
+
+		body := req.Body
+		if strings.Contains(req.Header.Get("Content-Encoding"), "gzip") {
+			gzr, err := gzip.NewReader(body)
+			if err != nil {
+				apiSendJSON(out, req, http.StatusBadRequest, &APIError{Code: http.StatusBadRequest, Message: "invalid gzip stream"})
+				return
+			}
+			defer gzr.Close()
+			body = ioutil.NopCloser(gzr)
+		}
+
+		flusher, canFlush := out.(http.Flusher)
+		out.Header().Set("Content-Type", "application/json")
+		out.WriteHeader(http.StatusOK)
+		enc := json.NewEncoder(out)
+
+		var lenBuf [4]byte
+		for {
+			if _, err := io.ReadFull(body, lenBuf[:]); err != nil {
+				return
+			}
+			rlen := binary.BigEndian.Uint32(lenBuf[:])
+			if rlen == 0 || rlen > RecordMaxSize {
+				return
+			}
+			rdata := make([]byte, rlen)
+			if _, err := io.ReadFull(body, rdata); err != nil {
+				return
+			}
+
+			var ack AddResult
+			rec, err := NewRecordFromBytes(rdata)
+			if err != nil {
+				ack.Error = err.Error()
+			} else {
+				ack.Hash = rec.Hash()
+				if err := n.addRecord(rec); err != nil {
+					ack.Error = err.Error()
+				} else {
+					metricRecordsImportedTotal.Add(1)
+				}
+			}
+			if enc.Encode(&ack) != nil {
+				return
+			}
+			if canFlush {
+				flusher.Flush()
+			}
+		}
+	})
+
+	// Streaming variant of /query: rather than buffering the whole QueryResults slice, results
+	// are emitted as NDJSON frames as soon as they (and any recursive resolution) are ready.
+	// If Since names a record the caller already has, everything up to and including it is
+	// skipped so a reconnecting client does not receive duplicates.
+	smux.HandleFunc("/query/stream", func(out http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost && req.Method != http.MethodPut {
+			apiSendJSON(out, req, http.StatusMethodNotAllowed, &APIError{Code: http.StatusMethodNotAllowed, Message: req.Method + " not supported for this path"})
+			return
+		}
+
+		var payload struct {
+			Query
+			Since *StreamCursor `json:",omitempty"`
+		}
+		if apiReadJSON(out, req, &payload) != nil {
+			return
+		}
+		q := payload.Query
+		if q.MaxResults == 0 || q.MaxResults > APIMaxResults {
+			q.MaxResults = APIMaxResults
+		}
+
+		results, err := n.db.getByQueryRange(&q)
+		if err != nil {
+			apiSendJSON(out, req, http.StatusInternalServerError, &APIError{Code: http.StatusInternalServerError, Message: err.Error()})
+			return
+		}
+
+		qr := queryResultsFromDetails(results)
+		if q.Recursive && len(results) > 0 {
+			qr, err = resolveRecursive(results, q.Owner, q.MaxDepth, func(sub *Query) ([]APIRecordDetail, error) {
+				if sub.MaxResults == 0 || sub.MaxResults > APIMaxResults {
+					sub.MaxResults = APIMaxResults
+				}
+				return n.db.getByQueryRange(sub)
+			})
+			if err != nil {
+				apiSendJSON(out, req, http.StatusInternalServerError, &APIError{Code: http.StatusInternalServerError, Message: err.Error()})
+				return
+			}
+		}
+
+		out.Header().Set("Content-Type", "application/x-ndjson")
+		out.WriteHeader(http.StatusOK)
+		if req.Method == http.MethodHead {
+			return
+		}
+
+		flusher, canFlush := out.(http.Flusher)
+		enc := json.NewEncoder(out)
+		skipping := payload.Since != nil
+		for i := range qr {
+			if skipping {
+				h := qr[i].Record.Hash()
+				if bytes.Equal(h[:], payload.Since.Hash) {
+					skipping = false
+				}
+				continue
+			}
+			if enc.Encode(&qr[i]) != nil {
+				return
+			}
+			if canFlush {
+				flusher.Flush()
+			}
+		}
+	})
+}
@@ -0,0 +1,352 @@
+/*
+ * LF: Global Fully Replicated Key/Value Store
+ * Copyright (C) 2018-2019  ZeroTier, Inc.  https://www.zerotier.com/
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ *
+ * --
+ *
+ * You can be released from the requirements of the license by purchasing
+ * a commercial license. Buying such a license is mandatory as soon as you
+ * develop commercial closed-source software that incorporates or links
+ * directly against ZeroTier software without disclosing the source code
+ * of your own application.
+ */
+
+package lf
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// rpcVersion is the only JSON-RPC version this server speaks.
+const rpcVersion = "2.0"
+
+// rpcConcurrency bounds how many requests within a single batch are dispatched in parallel.
+const rpcConcurrency = APIMaxResults
+
+// rpcRequest is a single JSON-RPC 2.0 request envelope.
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+// rpcError is the JSON-RPC 2.0 error object. Code follows the standard JSON-RPC ranges for
+// protocol-level problems; LF-specific APIError.Code values (which may be negative) are
+// carried through verbatim in Data so clients can distinguish application errors from
+// transport errors.
+type rpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// rpcResponse is a single JSON-RPC 2.0 response envelope. Result and Error are mutually exclusive.
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+)
+
+// rpcMethods maps JSON-RPC method names to handlers. Each handler decodes params itself (using
+// the same APIPut/APIGet/APIStatus shapes as the REST surface) and returns a result or an error.
+var rpcMethods = map[string]func(n *Node, req *http.Request, params json.RawMessage) (interface{}, *APIError){
+	"lf.put":     rpcMethodPut,
+	"lf.get":     rpcMethodGet,
+	"lf.links":   rpcMethodLinks,
+	"lf.status":  rpcMethodStatus,
+	"lf.peers":   rpcMethodPeers,
+	"lf.connect": rpcMethodConnect,
+	"lf.getRaw":  rpcMethodGetRaw,
+}
+
+func rpcMethodPut(n *Node, req *http.Request, params json.RawMessage) (interface{}, *APIError) {
+	var put APIPut
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, &put); err != nil {
+			return nil, &APIError{Code: http.StatusBadRequest, Message: "invalid params for lf.put"}
+		}
+	}
+
+	if len(put.Data) > 0 {
+		rec, err := NewRecordFromBytes(put.Data)
+		if err != nil {
+			return nil, &APIError{Code: http.StatusBadRequest, Message: "invalid or malformed payload"}
+		}
+		if !apiAuthorize(n, req, CapPut) && !apiAuthorizeRecordOIDC(n, rec, put.OIDCAuth) {
+			return nil, &APIError{Code: http.StatusForbidden, Message: "submitting records requires either the cap:put capability or a verifiable OIDC authorization"}
+		}
+		if err := n.addRecord(rec); err != nil {
+			return nil, &APIError{Code: http.StatusBadRequest, Message: err.Error()}
+		}
+		metricRecordsImportedTotal.Add(1)
+		return &APIError{Code: http.StatusOK, Message: "accepted"}, nil
+	}
+
+	if !apiAuthorize(n, req, requiredPutCapability(&put), CapRecordBuild) {
+		return nil, &APIError{Code: http.StatusForbidden, Message: "node will only build records locally for clients holding cap:record-build and the relevant cap:put grant"}
+	}
+	owner, err := NewOwnerFromPrivateBytes(put.OwnerPrivateKey)
+	if err != nil {
+		return nil, &APIError{Code: http.StatusBadRequest, Message: "invalid owner private key"}
+	}
+	var plainTextKey []byte
+	if put.PlainTextValue {
+		plainTextKey = put.Selectors[0]
+	}
+	wg := NewWharrgarblr(RecordDefaultWharrgarblMemory, 0)
+	rec, err := NewRecord(RecordTypeDatum, put.Value, nil, nil, put.Selectors[:], plainTextKey, nil, TimeSec(), wg, owner)
+	if err != nil {
+		return nil, &APIError{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	if err := n.addRecord(rec); err != nil {
+		return nil, &APIError{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	metricRecordsImportedTotal.Add(1)
+	return &APIRecordDetail{Record: *rec, Key: put.Key}, nil
+}
+
+func rpcMethodGet(n *Node, req *http.Request, params json.RawMessage) (interface{}, *APIError) {
+	var get APIGet
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, &get); err != nil {
+			return nil, &APIError{Code: http.StatusBadRequest, Message: "invalid params for lf.get"}
+		}
+	}
+	if get.MaxResults == 0 || get.MaxResults > APIMaxResults {
+		get.MaxResults = APIMaxResults
+	}
+
+	results, err := n.db.getByQuery(&get)
+	if err != nil {
+		return nil, &APIError{Code: http.StatusInternalServerError, Message: err.Error()}
+	}
+	if len(get.Key) > 0 {
+		for i := range results {
+			if plainTextValue, err := results[i].Record.Decrypt(get.Key); err == nil {
+				results[i].Key = get.Key
+				results[i].Value = plainTextValue
+			}
+		}
+	}
+	return results, nil
+}
+
+func rpcMethodLinks(n *Node, req *http.Request, params json.RawMessage) (interface{}, *APIError) {
+	var want APIRequestLinks
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, &want); err != nil {
+			return nil, &APIError{Code: http.StatusBadRequest, Message: "invalid params for lf.links"}
+		}
+	}
+	links, _, err := n.db.getLinks(want.Count)
+	if err != nil {
+		return nil, &APIError{Code: http.StatusInternalServerError, Message: err.Error()}
+	}
+	flat := make([]byte, 0, len(links)*32)
+	for i := range links {
+		flat = append(flat, links[i][:]...)
+	}
+	return &APILinks{Links: flat}, nil
+}
+
+func rpcMethodStatus(n *Node, req *http.Request, params json.RawMessage) (interface{}, *APIError) {
+	rc, ds := n.db.stats()
+	var s APIStatus
+	s.Software = SoftwareName
+	s.Version[0] = VersionMajor
+	s.Version[1] = VersionMinor
+	s.Version[2] = VersionRevision
+	s.Version[3] = VersionBuild
+	s.MinAPIVersion = APIVersion
+	s.MaxAPIVersion = APIVersion
+	s.Uptime = n.startTime
+	s.ConnectedPeers = apiMakePeerArray(n)
+	s.DBRecordCount = rc
+	s.DBSize = ds
+	return &s, nil
+}
+
+func rpcMethodPeers(n *Node, req *http.Request, params json.RawMessage) (interface{}, *APIError) {
+	if !apiAuthorize(n, req, CapStatusPeers) {
+		return nil, &APIError{Code: http.StatusForbidden, Message: "viewing peers requires the cap:status-peers capability"}
+	}
+	return apiMakePeerArray(n), nil
+}
+
+func rpcMethodConnect(n *Node, req *http.Request, params json.RawMessage) (interface{}, *APIError) {
+	if !apiAuthorize(n, req, CapConnect) {
+		return nil, &APIError{Code: http.StatusForbidden, Message: "submitting peers requires the cap:connect capability"}
+	}
+	var peer APIPeer
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, &peer); err != nil {
+			return nil, &APIError{Code: http.StatusBadRequest, Message: "invalid params for lf.connect"}
+		}
+	}
+	if err := n.connectHost(peer.IP, int(peer.Port)); err != nil {
+		return nil, &APIError{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	return &APIError{Code: http.StatusOK, Message: "connecting"}, nil
+}
+
+func rpcMethodGetRaw(n *Node, req *http.Request, params json.RawMessage) (interface{}, *APIError) {
+	var hashes struct {
+		Hashes [][]byte `json:"hashes"`
+	}
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, &hashes); err != nil {
+			return nil, &APIError{Code: http.StatusBadRequest, Message: "invalid params for lf.getRaw"}
+		}
+	}
+
+	results := make([]APIRecordDetail, 0, len(hashes.Hashes))
+	for _, hb := range hashes.Hashes {
+		if len(hb) != 32 {
+			continue
+		}
+		var h [32]byte
+		copy(h[:], hb)
+		rec, err := n.db.getByHash(h)
+		if err != nil || rec == nil {
+			continue
+		}
+		results = append(results, APIRecordDetail{Record: *rec})
+	}
+	return results, nil
+}
+
+// dispatchRPC runs a single JSON-RPC request and always returns a response, even for
+// notification-shaped requests (an empty ID), since LF does not use fire-and-forget semantics.
+func dispatchRPC(n *Node, req *http.Request, r *rpcRequest) *rpcResponse {
+	if r.JSONRPC != rpcVersion {
+		return &rpcResponse{JSONRPC: rpcVersion, ID: r.ID, Error: &rpcError{Code: rpcInvalidRequest, Message: "jsonrpc must be \"2.0\""}}
+	}
+	method, ok := rpcMethods[r.Method]
+	if !ok {
+		return &rpcResponse{JSONRPC: rpcVersion, ID: r.ID, Error: &rpcError{Code: rpcMethodNotFound, Message: "method not found: " + r.Method}}
+	}
+	result, apiErr := method(n, req, r.Params)
+	if apiErr != nil {
+		return &rpcResponse{JSONRPC: rpcVersion, ID: r.ID, Error: &rpcError{Code: rpcInternalError, Message: apiErr.Message, Data: apiErr.Code}}
+	}
+	return &rpcResponse{JSONRPC: rpcVersion, ID: r.ID, Result: result}
+}
+
+// dispatchRPCBatch runs every request in a batch concurrently (bounded by rpcConcurrency) and
+// returns responses in the same order as the input, as JSON-RPC 2.0 requires.
+func dispatchRPCBatch(n *Node, req *http.Request, reqs []rpcRequest) []rpcResponse {
+	out := make([]rpcResponse, len(reqs))
+	sem := make(chan struct{}, rpcConcurrency)
+	var wg sync.WaitGroup
+	for i := range reqs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			out[i] = *dispatchRPC(n, req, &reqs[i])
+		}(i)
+	}
+	wg.Wait()
+	return out
+}
+
+// apiAddRPCHandlers registers /rpc and /rpc/stream on smux.
+func apiAddRPCHandlers(smux *http.ServeMux, n *Node) {
+	// Single-shot JSON-RPC 2.0 endpoint. Accepts either one request object or a batch array.
+	smux.HandleFunc("/rpc", func(out http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost && req.Method != http.MethodPut {
+			apiSendJSON(out, req, http.StatusMethodNotAllowed, &APIError{Code: http.StatusMethodNotAllowed, Message: req.Method + " not supported for this path"})
+			return
+		}
+
+		var raw json.RawMessage
+		if apiReadJSON(out, req, &raw) != nil {
+			return
+		}
+
+		trimmed := bytesTrimLeadingSpace(raw)
+		if len(trimmed) > 0 && trimmed[0] == '[' {
+			var batch []rpcRequest
+			if err := json.Unmarshal(raw, &batch); err != nil {
+				apiSendJSON(out, req, http.StatusOK, &rpcResponse{JSONRPC: rpcVersion, Error: &rpcError{Code: rpcParseError, Message: "invalid batch"}})
+				return
+			}
+			apiSendJSON(out, req, http.StatusOK, dispatchRPCBatch(n, req, batch))
+			return
+		}
+
+		var single rpcRequest
+		if err := json.Unmarshal(raw, &single); err != nil {
+			apiSendJSON(out, req, http.StatusOK, &rpcResponse{JSONRPC: rpcVersion, Error: &rpcError{Code: rpcParseError, Message: "invalid request"}})
+			return
+		}
+		apiSendJSON(out, req, http.StatusOK, dispatchRPC(n, req, &single))
+	})
+
+	// Streaming variant: a persistent connection carrying newline-delimited JSON-RPC request
+	// frames, one response frame emitted per request as soon as it completes, so pipelined
+	// gets don't each pay for a TCP/TLS handshake.
+	smux.HandleFunc("/rpc/stream", func(out http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost && req.Method != http.MethodPut {
+			apiSendJSON(out, req, http.StatusMethodNotAllowed, &APIError{Code: http.StatusMethodNotAllowed, Message: req.Method + " not supported for this path"})
+			return
+		}
+		flusher, canFlush := out.(http.Flusher)
+		out.Header().Set("Content-Type", "application/json")
+		out.WriteHeader(http.StatusOK)
+
+		scanner := bufio.NewScanner(req.Body)
+		scanner.Buffer(make([]byte, 0, 64*1024), APIMaxResponseSize)
+		enc := json.NewEncoder(out)
+		for scanner.Scan() {
+			var r rpcRequest
+			var resp *rpcResponse
+			if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+				resp = &rpcResponse{JSONRPC: rpcVersion, Error: &rpcError{Code: rpcParseError, Message: "invalid request"}}
+			} else {
+				resp = dispatchRPC(n, req, &r)
+			}
+			if enc.Encode(resp) != nil {
+				return
+			}
+			if canFlush {
+				flusher.Flush()
+			}
+		}
+	})
+}
+
+func bytesTrimLeadingSpace(b []byte) []byte {
+	i := 0
+	for i < len(b) && (b[i] == ' ' || b[i] == '\t' || b[i] == '\n' || b[i] == '\r') {
+		i++
+	}
+	return b[i:]
+}
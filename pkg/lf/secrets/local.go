@@ -0,0 +1,214 @@
+/*
+ * LF: Global Fully Replicated Key/Value Store
+ * Copyright (C) 2018-2019  ZeroTier, Inc.  https://www.zerotier.com/
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ *
+ * --
+ *
+ * You can be released from the requirements of the license by purchasing
+ * a commercial license. Buying such a license is mandatory as soon as you
+ * develop commercial closed-source software that incorporates or links
+ * directly against ZeroTier software without disclosing the source code
+ * of your own application.
+ */
+
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const localSecretExt = ".secret"
+
+// localEnvelopeMagic prefixes files that are passphrase-encrypted so HasSecret/GetSecret
+// can tell an envelope apart from a plaintext blob written by an older version.
+var localEnvelopeMagic = [4]byte{'L', 'F', 'S', '1'}
+
+const (
+	localScryptN   = 1 << 15
+	localScryptR   = 8
+	localScryptP   = 1
+	localSaltSize  = 16
+	localNonceSize = 12
+	localKeySize   = 32
+)
+
+// LocalConfig configures the local file system secrets backend.
+type LocalConfig struct {
+	Path       string `json:",omitempty"` // Directory in which secrets are stored (created with 0700 if missing)
+	Passphrase string `json:",omitempty"` // If non-empty, secrets are wrapped in an AES-256-GCM envelope derived from this passphrase
+}
+
+// localManager stores each secret as an individual file in a directory with 0600 permissions.
+// If a passphrase is configured, secret contents are sealed in an AES-256-GCM envelope
+// keyed by scrypt(passphrase, salt).
+type localManager struct {
+	path       string
+	passphrase string
+}
+
+// NewLocalManager creates (if needed) and returns a local directory-backed secrets Manager.
+func NewLocalManager(cfg LocalConfig) (Manager, error) {
+	path := cfg.Path
+	if len(path) == 0 {
+		path = "./secrets"
+	}
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return nil, err
+	}
+	_ = os.Chmod(path, 0700)
+	return &localManager{path: path, passphrase: cfg.Passphrase}, nil
+}
+
+func (m *localManager) secretPath(name string) (string, error) {
+	if len(name) == 0 || strings.ContainsAny(name, "/\\") || name == "." || name == ".." {
+		return "", ErrSecretNotFound
+	}
+	return filepath.Join(m.path, name+localSecretExt), nil
+}
+
+func (m *localManager) GetSecret(name string) ([]byte, error) {
+	p, err := m.secretPath(name)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := ioutil.ReadFile(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrSecretNotFound
+		}
+		return nil, err
+	}
+	return m.unseal(raw)
+}
+
+func (m *localManager) SetSecret(name string, data []byte) error {
+	p, err := m.secretPath(name)
+	if err != nil {
+		return err
+	}
+	sealed, err := m.seal(data)
+	if err != nil {
+		return err
+	}
+	tmp := p + ".tmp"
+	if err := ioutil.WriteFile(tmp, sealed, 0600); err != nil {
+		return err
+	}
+	_ = os.Chmod(tmp, 0600)
+	return os.Rename(tmp, p)
+}
+
+func (m *localManager) HasSecret(name string) bool {
+	p, err := m.secretPath(name)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(p)
+	return err == nil
+}
+
+func (m *localManager) ListSecrets() ([]string, error) {
+	entries, err := ioutil.ReadDir(m.path)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), localSecretExt) {
+			names = append(names, strings.TrimSuffix(e.Name(), localSecretExt))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// seal wraps data in an AES-256-GCM envelope if a passphrase is configured, otherwise
+// it is written out as-is (still protected solely by the 0600/0700 file permissions).
+func (m *localManager) seal(data []byte) ([]byte, error) {
+	if len(m.passphrase) == 0 {
+		return data, nil
+	}
+
+	salt := make([]byte, localSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key, err := scrypt.Key([]byte(m.passphrase), salt, localScryptN, localScryptR, localScryptP, localKeySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, localNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	sealed := gcm.Seal(nil, nonce, data, nil)
+	out := make([]byte, 0, 4+len(salt)+len(nonce)+len(sealed))
+	out = append(out, localEnvelopeMagic[:]...)
+	out = append(out, salt...)
+	out = append(out, nonce...)
+	out = append(out, sealed...)
+	return out, nil
+}
+
+func (m *localManager) unseal(raw []byte) ([]byte, error) {
+	if len(raw) < 4 || string(raw[0:4]) != string(localEnvelopeMagic[:]) {
+		// Not an envelope: either no passphrase is in use or this is a legacy plaintext secret.
+		return raw, nil
+	}
+	if len(m.passphrase) == 0 {
+		return nil, errors.New("secret is passphrase-encrypted but no passphrase is configured")
+	}
+	raw = raw[4:]
+	if len(raw) < localSaltSize+localNonceSize {
+		return nil, errors.New("corrupt secret envelope")
+	}
+	salt := raw[:localSaltSize]
+	nonce := raw[localSaltSize : localSaltSize+localNonceSize]
+	ciphertext := raw[localSaltSize+localNonceSize:]
+
+	key, err := scrypt.Key([]byte(m.passphrase), salt, localScryptN, localScryptR, localScryptP, localKeySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
@@ -0,0 +1,79 @@
+/*
+ * LF: Global Fully Replicated Key/Value Store
+ * Copyright (C) 2018-2019  ZeroTier, Inc.  https://www.zerotier.com/
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ *
+ * --
+ *
+ * You can be released from the requirements of the license by purchasing
+ * a commercial license. Buying such a license is mandatory as soon as you
+ * develop commercial closed-source software that incorporates or links
+ * directly against ZeroTier software without disclosing the source code
+ * of your own application.
+ */
+
+package secrets
+
+import "sync"
+
+// memoryManager is a non-persistent, in-process Manager. It never touches disk or any
+// network service, so it is the backend callers get when they want a private key to flow
+// through the Manager interface (rather than being handled as a bare byte slice) without
+// actually asking for it to be retained anywhere.
+type memoryManager struct {
+	mu      sync.Mutex
+	secrets map[string][]byte
+}
+
+// NewMemoryManager returns a Manager backed by a plain in-memory map. Secrets stored here
+// do not survive process exit and are never written to disk; use it for call paths that
+// need to route key material through the Manager interface without requesting persistence.
+func NewMemoryManager() Manager {
+	return &memoryManager{secrets: make(map[string][]byte)}
+}
+
+func (m *memoryManager) GetSecret(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.secrets[name]
+	if !ok {
+		return nil, ErrSecretNotFound
+	}
+	return append([]byte(nil), data...), nil
+}
+
+func (m *memoryManager) SetSecret(name string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.secrets[name] = append([]byte(nil), data...)
+	return nil
+}
+
+func (m *memoryManager) HasSecret(name string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.secrets[name]
+	return ok
+}
+
+func (m *memoryManager) ListSecrets() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.secrets))
+	for name := range m.secrets {
+		names = append(names, name)
+	}
+	return names, nil
+}
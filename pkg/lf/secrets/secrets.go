@@ -0,0 +1,97 @@
+/*
+ * LF: Global Fully Replicated Key/Value Store
+ * Copyright (C) 2018-2019  ZeroTier, Inc.  https://www.zerotier.com/
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ *
+ * --
+ *
+ * You can be released from the requirements of the license by purchasing
+ * a commercial license. Buying such a license is mandatory as soon as you
+ * develop commercial closed-source software that incorporates or links
+ * directly against ZeroTier software without disclosing the source code
+ * of your own application.
+ */
+
+// Package secrets provides pluggable storage backends for sensitive key material
+// such as Owner private keys, so that callers never have to read or write them
+// to disk directly.
+package secrets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrSecretNotFound is returned by GetSecret when no secret exists under the given name.
+var ErrSecretNotFound = errors.New("secret not found")
+
+// ErrNotSupported is returned by backends for operations they do not implement.
+var ErrNotSupported = errors.New("operation not supported by this secrets backend")
+
+// Manager is implemented by all secret storage backends. Names are opaque strings
+// chosen by the caller (e.g. "genesis-owner" or "node-identity") and are never
+// interpreted as file system paths by callers of this interface.
+type Manager interface {
+	// GetSecret retrieves the raw bytes stored under name.
+	GetSecret(name string) ([]byte, error)
+
+	// SetSecret stores data under name, creating or overwriting it.
+	SetSecret(name string, data []byte) error
+
+	// HasSecret returns true if a secret exists under name.
+	HasSecret(name string) bool
+
+	// ListSecrets returns the names of all secrets currently known to this backend.
+	ListSecrets() ([]string, error)
+}
+
+// BackendConfig holds backend-specific configuration as raw JSON, decoded by the
+// backend itself once its name is known.
+type BackendConfig struct {
+	Backend string          `json:",omitempty"` // Backend name: "local", "hashicorp-vault", etc.
+	Config  json.RawMessage `json:",omitempty"` // Backend-specific configuration
+}
+
+// SecretsConfig is the JSON document a node loads to select and configure a secrets backend.
+type SecretsConfig struct {
+	BackendConfig
+}
+
+// NewManager constructs the Manager described by cfg.
+func NewManager(cfg *SecretsConfig) (Manager, error) {
+	if cfg == nil {
+		return NewLocalManager(LocalConfig{})
+	}
+	switch cfg.Backend {
+	case "", "local":
+		var lc LocalConfig
+		if len(cfg.Config) > 0 {
+			if err := json.Unmarshal(cfg.Config, &lc); err != nil {
+				return nil, err
+			}
+		}
+		return NewLocalManager(lc)
+	case "hashicorp-vault":
+		var vc VaultConfig
+		if len(cfg.Config) > 0 {
+			if err := json.Unmarshal(cfg.Config, &vc); err != nil {
+				return nil, err
+			}
+		}
+		return NewVaultManager(vc)
+	}
+	return nil, fmt.Errorf("unknown secrets backend: %s", cfg.Backend)
+}
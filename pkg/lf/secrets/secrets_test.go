@@ -0,0 +1,120 @@
+/*
+ * LF: Global Fully Replicated Key/Value Store
+ * Copyright (C) 2018-2019  ZeroTier, Inc.  https://www.zerotier.com/
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ *
+ * --
+ *
+ * You can be released from the requirements of the license by purchasing
+ * a commercial license. Buying such a license is mandatory as soon as you
+ * develop commercial closed-source software that incorporates or links
+ * directly against ZeroTier software without disclosing the source code
+ * of your own application.
+ */
+
+package secrets
+
+import (
+	"bytes"
+	"testing"
+)
+
+// backends returns one Manager per backend under test, named for use in subtests. Vault is
+// excluded since it requires a live server; local and memory are exercised here as the two
+// backends that are actually mockable in-process.
+func backends(t *testing.T) map[string]Manager {
+	t.Helper()
+	local, err := NewLocalManager(LocalConfig{Path: t.TempDir()})
+	if err != nil {
+		t.Fatalf("NewLocalManager: %v", err)
+	}
+	encryptedLocal, err := NewLocalManager(LocalConfig{Path: t.TempDir(), Passphrase: "correct horse battery staple"})
+	if err != nil {
+		t.Fatalf("NewLocalManager (encrypted): %v", err)
+	}
+	return map[string]Manager{
+		"local":           local,
+		"local-encrypted": encryptedLocal,
+		"memory":          NewMemoryManager(),
+	}
+}
+
+func TestManagerRoundTrip(t *testing.T) {
+	for name, m := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			if m.HasSecret("owner-key") {
+				t.Fatal("HasSecret returned true before any secret was set")
+			}
+			if _, err := m.GetSecret("owner-key"); err != ErrSecretNotFound {
+				t.Fatalf("GetSecret on missing secret: got err %v, want ErrSecretNotFound", err)
+			}
+
+			want := []byte{0x01, 0x02, 0x03, 0xff, 0x00, 0xfe}
+			if err := m.SetSecret("owner-key", want); err != nil {
+				t.Fatalf("SetSecret: %v", err)
+			}
+			if !m.HasSecret("owner-key") {
+				t.Fatal("HasSecret returned false after SetSecret")
+			}
+
+			got, err := m.GetSecret("owner-key")
+			if err != nil {
+				t.Fatalf("GetSecret: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("GetSecret returned %x, want %x", got, want)
+			}
+
+			if err := m.SetSecret("owner-key", []byte{0xaa}); err != nil {
+				t.Fatalf("SetSecret (overwrite): %v", err)
+			}
+			got, err = m.GetSecret("owner-key")
+			if err != nil {
+				t.Fatalf("GetSecret after overwrite: %v", err)
+			}
+			if !bytes.Equal(got, []byte{0xaa}) {
+				t.Fatalf("GetSecret after overwrite returned %x, want aa", got)
+			}
+
+			names, err := m.ListSecrets()
+			if err != nil {
+				t.Fatalf("ListSecrets: %v", err)
+			}
+			found := false
+			for _, n := range names {
+				if n == "owner-key" {
+					found = true
+				}
+			}
+			if !found {
+				t.Fatalf("ListSecrets %v does not include owner-key", names)
+			}
+		})
+	}
+}
+
+func TestNewManagerSelectsBackendByName(t *testing.T) {
+	m, err := NewManager(&SecretsConfig{BackendConfig: BackendConfig{Backend: "local", Config: []byte(`{"Path":"` + t.TempDir() + `"}`)}})
+	if err != nil {
+		t.Fatalf("NewManager(local): %v", err)
+	}
+	if err := m.SetSecret("x", []byte("y")); err != nil {
+		t.Fatalf("SetSecret: %v", err)
+	}
+
+	if _, err := NewManager(&SecretsConfig{BackendConfig: BackendConfig{Backend: "does-not-exist"}}); err == nil {
+		t.Fatal("NewManager with an unknown backend name should fail")
+	}
+}
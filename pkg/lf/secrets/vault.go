@@ -0,0 +1,161 @@
+/*
+ * LF: Global Fully Replicated Key/Value Store
+ * Copyright (C) 2018-2019  ZeroTier, Inc.  https://www.zerotier.com/
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ *
+ * --
+ *
+ * You can be released from the requirements of the license by purchasing
+ * a commercial license. Buying such a license is mandatory as soon as you
+ * develop commercial closed-source software that incorporates or links
+ * directly against ZeroTier software without disclosing the source code
+ * of your own application.
+ */
+
+package secrets
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	vaultapi "github.com/hashicorp/vault/api"
+)
+
+// VaultConfig configures the HashiCorp Vault KV v2 secrets backend.
+type VaultConfig struct {
+	Address  string `json:",omitempty"` // Vault server address, e.g. https://vault.example.com:8200
+	Mount    string `json:",omitempty"` // KV v2 mount point, defaults to "secret"
+	Prefix   string `json:",omitempty"` // Path prefix under the mount at which secrets are stored, defaults to "lf"
+	Token    string `json:",omitempty"` // Static token, used if set
+	Role     string `json:",omitempty"` // AppRole role_id, used with Secret if Token is empty
+	Secret   string `json:",omitempty"` // AppRole secret_id, used with Role if Token is empty
+	Insecure bool   `json:",omitempty"` // Skip TLS verification (for testing only)
+}
+
+// vaultManager stores secrets in a HashiCorp Vault KV v2 engine, one secret per key/value
+// pair under path/to/mount/data/<prefix>/<name>. Values are base64-encoded since Vault's
+// KV v2 engine stores string-keyed JSON values rather than arbitrary binary blobs.
+type vaultManager struct {
+	client *vaultapi.Client
+	mount  string
+	prefix string
+}
+
+const vaultDataField = "data"
+
+// NewVaultManager authenticates to Vault (by token or AppRole) and returns a KV v2 backed Manager.
+func NewVaultManager(cfg VaultConfig) (Manager, error) {
+	vc := vaultapi.DefaultConfig()
+	if len(cfg.Address) > 0 {
+		vc.Address = cfg.Address
+	}
+	if cfg.Insecure {
+		if err := vc.ConfigureTLS(&vaultapi.TLSConfig{Insecure: true}); err != nil {
+			return nil, err
+		}
+	}
+	client, err := vaultapi.NewClient(vc)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cfg.Token) > 0 {
+		client.SetToken(cfg.Token)
+	} else if len(cfg.Role) > 0 {
+		secret, err := client.Logical().Write("auth/approle/login", map[string]interface{}{
+			"role_id":   cfg.Role,
+			"secret_id": cfg.Secret,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if secret == nil || secret.Auth == nil || len(secret.Auth.ClientToken) == 0 {
+			return nil, fmt.Errorf("vault approle login did not return a client token")
+		}
+		client.SetToken(secret.Auth.ClientToken)
+	} else {
+		return nil, fmt.Errorf("vault backend requires either a token or an AppRole role/secret pair")
+	}
+
+	mount := cfg.Mount
+	if len(mount) == 0 {
+		mount = "secret"
+	}
+	prefix := cfg.Prefix
+	if len(prefix) == 0 {
+		prefix = "lf"
+	}
+
+	return &vaultManager{client: client, mount: mount, prefix: prefix}, nil
+}
+
+func (m *vaultManager) dataPath(name string) string {
+	return fmt.Sprintf("%s/data/%s/%s", m.mount, m.prefix, name)
+}
+
+func (m *vaultManager) metadataPath() string {
+	return fmt.Sprintf("%s/metadata/%s", m.mount, m.prefix)
+}
+
+func (m *vaultManager) GetSecret(name string) ([]byte, error) {
+	secret, err := m.client.Logical().Read(m.dataPath(name))
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, ErrSecretNotFound
+	}
+	data, _ := secret.Data["data"].(map[string]interface{})
+	if data == nil {
+		return nil, ErrSecretNotFound
+	}
+	enc, ok := data[vaultDataField].(string)
+	if !ok {
+		return nil, ErrSecretNotFound
+	}
+	return base64.StdEncoding.DecodeString(enc)
+}
+
+func (m *vaultManager) SetSecret(name string, data []byte) error {
+	_, err := m.client.Logical().Write(m.dataPath(name), map[string]interface{}{
+		"data": map[string]interface{}{
+			vaultDataField: base64.StdEncoding.EncodeToString(data),
+		},
+	})
+	return err
+}
+
+func (m *vaultManager) HasSecret(name string) bool {
+	_, err := m.GetSecret(name)
+	return err == nil
+}
+
+func (m *vaultManager) ListSecrets() ([]string, error) {
+	secret, err := m.client.Logical().List(m.metadataPath())
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, nil
+	}
+	keysRaw, _ := secret.Data["keys"].([]interface{})
+	names := make([]string, 0, len(keysRaw))
+	for _, k := range keysRaw {
+		if s, ok := k.(string); ok {
+			names = append(names, s)
+		}
+	}
+	return names, nil
+}
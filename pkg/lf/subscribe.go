@@ -0,0 +1,271 @@
+/*
+ * LF: Global Fully Replicated Key/Value Store
+ * Copyright (C) 2018-2019  ZeroTier, Inc.  https://www.zerotier.com/
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ *
+ * --
+ *
+ * You can be released from the requirements of the license by purchasing
+ * a commercial license. Buying such a license is mandatory as soon as you
+ * develop commercial closed-source software that incorporates or links
+ * directly against ZeroTier software without disclosing the source code
+ * of your own application.
+ */
+
+package lf
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack"
+)
+
+// subscribeMaxPerIP caps how many concurrent /subscribe connections a single remote IP may
+// hold open, to keep one client from exhausting the node's subscriber slots.
+const subscribeMaxPerIP = 8
+
+// subscribeBufferSize bounds how many pending APIRecordDetail messages a subscriber will
+// buffer before being considered slow and dropped.
+const subscribeBufferSize = 256
+
+// subscribeHeartbeatInterval is how often an idle subscriber receives a heartbeat frame.
+const subscribeHeartbeatInterval = 30 * time.Second
+
+// subscriber is one live /subscribe registration.
+type subscriber struct {
+	filter   APIGet
+	ch       chan *APIRecordDetail
+	remoteIP string
+}
+
+// subscriberRegistry tracks all live subscribers for a Node, protected by a RWMutex since
+// Publish (called from the record-ingest hot path) is far more frequent than Subscribe/cancel.
+type subscriberRegistry struct {
+	mu          sync.RWMutex
+	subscribers map[*subscriber]struct{}
+	perIPCount  map[string]int
+}
+
+func newSubscriberRegistry() *subscriberRegistry {
+	return &subscriberRegistry{subscribers: make(map[*subscriber]struct{}), perIPCount: make(map[string]int)}
+}
+
+// Subscribe registers filter and returns a channel of matching records plus a cancel function.
+// It returns ok=false if remoteIP already holds subscribeMaxPerIP live subscriptions.
+func (r *subscriberRegistry) Subscribe(filter APIGet, remoteIP string) (<-chan *APIRecordDetail, func(), bool) {
+	r.mu.Lock()
+	if remoteIP != subscribeLocalCaller && r.perIPCount[remoteIP] >= subscribeMaxPerIP {
+		r.mu.Unlock()
+		return nil, nil, false
+	}
+	sub := &subscriber{filter: filter, ch: make(chan *APIRecordDetail, subscribeBufferSize), remoteIP: remoteIP}
+	r.subscribers[sub] = struct{}{}
+	r.perIPCount[remoteIP]++
+	r.mu.Unlock()
+
+	cancel := func() {
+		r.mu.Lock()
+		if _, ok := r.subscribers[sub]; ok {
+			delete(r.subscribers, sub)
+			r.perIPCount[remoteIP]--
+			close(sub.ch)
+		}
+		r.mu.Unlock()
+	}
+	return sub.ch, cancel, true
+}
+
+// Publish fans rd out to every subscriber whose filter matches it. A subscriber whose buffer
+// is full (a slow consumer) is dropped rather than blocking record ingest: it is unregistered
+// and its channel is closed, which ends its /subscribe stream.
+func (r *subscriberRegistry) Publish(rd *APIRecordDetail) {
+	var slow []*subscriber
+	r.mu.RLock()
+	for sub := range r.subscribers {
+		if !subscribeMatches(sub.filter, rd) {
+			continue
+		}
+		select {
+		case sub.ch <- rd:
+		default:
+			slow = append(slow, sub)
+		}
+	}
+	r.mu.RUnlock()
+	if len(slow) > 0 {
+		r.mu.Lock()
+		for _, sub := range slow {
+			if _, ok := r.subscribers[sub]; ok {
+				delete(r.subscribers, sub)
+				r.perIPCount[sub.remoteIP]--
+				close(sub.ch)
+			}
+		}
+		r.mu.Unlock()
+	}
+}
+
+// subscribeMatches reports whether rd satisfies filter's key/owner/selector criteria.
+func subscribeMatches(filter APIGet, rd *APIRecordDetail) bool {
+	if len(filter.Owner) > 0 && !bytes.Equal(filter.Owner, rd.Record.Owner) {
+		return false
+	}
+	if len(filter.Key) > 0 {
+		// Key overrides ID (see APIGet's doc comment): the same way /g treats a supplied
+		// plain-text key as authoritative over a supplied ID, a record matches only if it
+		// actually decrypts under this key, not merely if filter.ID (left empty by a
+		// key-only caller) happens to equal it.
+		if _, err := rd.Record.Decrypt(filter.Key); err != nil {
+			return false
+		}
+	} else if len(filter.ID) > 0 && !bytes.Equal(filter.ID, rd.Record.ID()) {
+		return false
+	}
+	for i := range filter.SelectorIDs {
+		if len(filter.SelectorIDs[i]) > 0 {
+			if i >= len(rd.Record.Selectors) || !bytes.Equal(filter.SelectorIDs[i], rd.Record.Selectors[i].ID()) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// subscribeLocalCaller is the pseudo remote-IP used for in-process Subscribe callers, who are
+// not subject to the per-IP cap enforced against HTTP /subscribe clients.
+const subscribeLocalCaller = ""
+
+// Subscribe registers a live filter against this node's record-ingest path. The returned
+// channel receives an APIRecordDetail for every subsequently committed record that matches
+// filter; the cancel function must be called to release the subscription's slot.
+func (n *Node) Subscribe(filter APIGet) (<-chan *APIRecordDetail, func()) {
+	ch, cancel, _ := n.subscribers.Subscribe(filter, subscribeLocalCaller)
+	return ch, cancel
+}
+
+// apiAddSubscribeHandler registers /subscribe on smux.
+func apiAddSubscribeHandler(smux *http.ServeMux, n *Node) {
+	upgrader := websocket.Upgrader{ReadBufferSize: 4096, WriteBufferSize: 4096}
+
+	smux.HandleFunc("/subscribe", func(out http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodPost && req.Method != http.MethodPut {
+			apiSendJSON(out, req, http.StatusMethodNotAllowed, &APIError{Code: http.StatusMethodNotAllowed, Message: req.Method + " not supported for this path"})
+			return
+		}
+
+		var filter APIGet
+		if req.Method == http.MethodGet {
+			// Filters may also be supplied via query string for plain WebSocket clients that
+			// cannot easily send a body as part of the upgrade handshake.
+			if q := req.URL.Query().Get("filter"); len(q) > 0 {
+				_ = json.Unmarshal([]byte(q), &filter)
+			}
+		} else if apiReadJSON(out, req, &filter) != nil {
+			return
+		}
+
+		remoteIP := req.RemoteAddr
+		if i := strings.LastIndexByte(remoteIP, ':'); i >= 0 {
+			remoteIP = remoteIP[:i]
+		}
+
+		ch, cancel, ok := n.subscribers.Subscribe(filter, remoteIP)
+		if !ok {
+			apiSendJSON(out, req, http.StatusTooManyRequests, &APIError{Code: http.StatusTooManyRequests, Message: "too many concurrent subscriptions from this address"})
+			return
+		}
+		defer cancel()
+
+		if strings.Contains(strings.ToLower(req.Header.Get("Upgrade")), "websocket") {
+			serveSubscribeWebSocket(upgrader, out, req, ch)
+			return
+		}
+		serveSubscribeChunked(out, req, ch)
+	})
+}
+
+func serveSubscribeChunked(out http.ResponseWriter, req *http.Request, ch <-chan *APIRecordDetail) {
+	flusher, canFlush := out.(http.Flusher)
+	usesMsgpack := strings.Contains(req.Header.Get("Accept"), "msgpack")
+	if usesMsgpack {
+		out.Header().Set("Content-Type", "application/x-msgpack-stream")
+	} else {
+		out.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	out.WriteHeader(http.StatusOK)
+
+	heartbeat := time.NewTicker(subscribeHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	var encode func(interface{}) error
+	if usesMsgpack {
+		enc := msgpack.NewEncoder(out)
+		encode = enc.Encode
+	} else {
+		enc := json.NewEncoder(out)
+		encode = enc.Encode
+	}
+	for {
+		select {
+		case rd, open := <-ch:
+			if !open {
+				return
+			}
+			if encode(rd) != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := out.Write([]byte("{}\n")); err != nil {
+				return
+			}
+		}
+		if canFlush {
+			flusher.Flush()
+		}
+	}
+}
+
+func serveSubscribeWebSocket(upgrader websocket.Upgrader, out http.ResponseWriter, req *http.Request, ch <-chan *APIRecordDetail) {
+	conn, err := upgrader.Upgrade(out, req, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	heartbeat := time.NewTicker(subscribeHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case rd, open := <-ch:
+			if !open {
+				return
+			}
+			if conn.WriteJSON(rd) != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if conn.WriteMessage(websocket.PingMessage, nil) != nil {
+				return
+			}
+		}
+	}
+}